@@ -0,0 +1,89 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"js353.com/timesync-mini/pkg/timesync"
+)
+
+// runCheck measures the offset without ever touching the clock, so it needs
+// no privileges. It prints one parsable line, seconds for offset and rtt, and
+// returns the exit code: 0 when the offset is within cfg.Threshold, 1 when it
+// is above, the code of the failure class when no server could be queried.
+func runCheck(ctx context.Context, cfg *Config, syslogWriter timesync.Notifier) int {
+	result, err := timesync.Sync(ctx, cfg.options(syslogWriter))
+	if cfg.JSON {
+		printResult(cfg, result, err)
+	}
+	exportResult(cfg, result, err, syslogWriter)
+	if err != nil {
+		return exitCode(err)
+	}
+	printOffset(cfg, result, err)
+	return reportCheck(cfg, result)
+}
+
+// runSetOnlyIfOff steps the clock like a plain run when the offset is above
+// the step threshold, and otherwise leaves it alone and reports like
+// runCheck, slewing is never used.
+func runSetOnlyIfOff(ctx context.Context, cfg *Config, syslogWriter timesync.Notifier) int {
+	result, err := syncOnce(ctx, cfg, syslogWriter)
+	if err != nil {
+		return exitCode(err)
+	}
+	if result.Action == timesync.ActionStep {
+		slog.Info("Offset above the step threshold, stepping the clock", "offset", result.Offset, "threshold", cfg.StepThreshold)
+		syslogWriter.Notice(fmt.Sprintf("Offset %v above %v, stepping the clock", result.Offset, cfg.StepThreshold))
+		return exitOK
+	}
+	// Above the threshold, the step was refused by -step-cooldown or
+	// -no-backward, which already logged why. The comparison is in whole
+	// milliseconds like the one of the library.
+	if result.Offset.Abs().Milliseconds() > cfg.StepThreshold.Milliseconds() {
+		slog.Warn("Offset above the step threshold, step refused (cooldown/no-backward), clock left alone", "offset", result.Offset, "threshold", cfg.StepThreshold)
+		syslogWriter.Warning(fmt.Sprintf("Offset %v above %v, step refused (cooldown/no-backward), clock left alone", result.Offset, cfg.StepThreshold))
+		return reportCheck(cfg, result)
+	}
+	slog.Info("Offset within the step threshold, clock left alone", "offset", result.Offset, "threshold", cfg.StepThreshold)
+	syslogWriter.Info(fmt.Sprintf("Offset %v within %v, clock left alone", result.Offset, cfg.StepThreshold))
+	return reportCheck(cfg, result)
+}
+
+// reportCheck prints the parsable line of a check, unless -json or
+// -print-offset already printed the result, and returns exitOffset when the
+// offset is above cfg.Threshold.
+func reportCheck(cfg *Config, result timesync.Result) int {
+	if !cfg.JSON && !cfg.PrintOffset {
+		fmt.Printf("server=%s offset=%.6f rtt=%.6f stratum=%d\n",
+			result.Server, result.Offset.Seconds(), result.RTT.Seconds(), result.Stratum)
+	}
+	if result.Offset.Abs() > cfg.Threshold {
+		return exitOffset
+	}
+	return exitOK
+}
@@ -0,0 +1,140 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+)
+
+// command holds a subcommand of the CLI.
+// Fields:
+// - name: Word selecting the command, first argument of the command line.
+// - summary: One line description shown in the usage.
+// - without: Flags that make no sense for the command and are not accepted.
+// - apply: Sets the mode of the command on the parsed configuration.
+type command struct {
+	name    string
+	summary string
+	without []string
+	apply   func(cfg *Config)
+}
+
+// clockFlags set or guard the system clock, a report-only run has no use
+// for them.
+var clockFlags = []string{"n", "slew", "step-threshold", "min-adjust", "panic-threshold", "force", "force-year",
+	"sync-rtc", "set-only-if-off", "step-cooldown", "min-interval", "drift-correct"}
+
+// daemonFlags only apply to the daemon loop.
+var daemonFlags = []string{"d", "i", "history", "http"}
+
+// reportFlags select the report-only modes.
+var reportFlags = []string{"check", "threshold", "compare", "tolerance"}
+
+// commands are the subcommands of the CLI. Without any, every flag is
+// accepted as before they existed.
+var commands = []command{
+	{
+		name:    "sync",
+		summary: "Set the system clock from the servers once (default)",
+		without: slices.Concat(daemonFlags, reportFlags, []string{"version"}),
+		apply:   func(cfg *Config) {},
+	},
+	{
+		name:    "query",
+		summary: "Only report the offset like -check, or compare the servers with -compare",
+		without: slices.Concat(clockFlags, daemonFlags, []string{"check", "version"}),
+		apply: func(cfg *Config) {
+			if !cfg.Compare {
+				cfg.Check = true
+			}
+		},
+	},
+	{
+		name:    "monitor",
+		summary: "Keep the clock in sync, re-syncing every -i like -d",
+		without: slices.Concat(reportFlags, []string{"d", "deadline", "min-interval", "set-only-if-off", "version"}),
+		apply:   func(cfg *Config) { cfg.Daemon = true },
+	},
+	{
+		name:    "version",
+		summary: "Print version information and exit",
+	},
+	{
+		name:    "completion",
+		summary: "Print the bash, zsh or fish completion script",
+	},
+}
+
+// findCommand splits the subcommand off args, nil when args start with a
+// flag or a server instead.
+func findCommand(args []string) (*command, []string) {
+	if len(args) == 0 {
+		return nil, args
+	}
+	for i := range commands {
+		if commands[i].name == args[0] {
+			return &commands[i], args[1:]
+		}
+	}
+	return nil, args
+}
+
+// flagSet returns the flags of the command, taken from all, the set of
+// every flag. They share the values of all, parsing either fills cfg.
+func (c *command) flagSet(all *flag.FlagSet) *flag.FlagSet {
+	fs := flag.NewFlagSet("timesync "+c.name, flag.ContinueOnError)
+	if c.apply == nil {
+		// version takes no option but the help.
+		fs.Var(all.Lookup("h").Value, "h", "Display usage")
+	} else {
+		all.VisitAll(func(f *flag.Flag) {
+			if !slices.Contains(c.without, f.Name) {
+				fs.Var(f.Value, f.Name, f.Usage)
+			}
+		})
+	}
+	fs.Usage = func() {
+		if c.apply == nil {
+			fmt.Fprintf(os.Stderr, "Usage: %s %s\n%s\n", os.Args[0], c.name, c.summary)
+			return
+		}
+		fmt.Fprintf(os.Stderr, "Usage: %s %s [options] <ntp-server[:port]>\n%s\nOptions:\n", os.Args[0], c.name, c.summary)
+		fs.PrintDefaults()
+		fmt.Fprint(os.Stderr, exitCodesUsage)
+	}
+	fs.SetOutput(os.Stderr)
+	return fs
+}
+
+// commandsUsage lists the subcommands for the usage of the flag-only form.
+func commandsUsage() string {
+	usage := "Commands (see <command> -h):\n"
+	for _, c := range commands {
+		usage += fmt.Sprintf("  %-8s %s\n", c.name, c.summary)
+	}
+	return usage
+}
@@ -0,0 +1,61 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"js353.com/timesync-mini/pkg/timesync"
+)
+
+// runCompare queries every server without touching the clock and prints a
+// table of their offsets followed by the spread between the extreme ones.
+// It returns the exit code: 0 when the spread is within cfg.Tolerance, 1
+// when it is above, the code of the failure class when no server answered.
+func runCompare(ctx context.Context, cfg *Config, syslogWriter timesync.Notifier) int {
+	results, err := timesync.Compare(ctx, cfg.options(syslogWriter))
+	if err != nil {
+		return exitCode(err)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "server\tip\toffset\trtt\tstratum")
+	low, high := results[0].Offset, results[0].Offset
+	for _, result := range results {
+		fmt.Fprintf(tw, "%s\t%s\t%.6f\t%.6f\t%d\n",
+			result.Server, result.IP, result.Offset.Seconds(), result.RTT.Seconds(), result.Stratum)
+		low = min(low, result.Offset)
+		high = max(high, result.Offset)
+	}
+	tw.Flush()
+	spread := high - low
+	fmt.Printf("servers=%d/%d spread=%.6f tolerance=%.6f\n",
+		len(results), len(cfg.Servers), spread.Seconds(), cfg.Tolerance.Seconds())
+	if spread > cfg.Tolerance {
+		return exitOffset
+	}
+	return exitOK
+}
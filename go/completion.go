@@ -0,0 +1,242 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// fileFlags take a path, the shells complete file names after them.
+var fileFlags = []string{"servers-file", "state-file", "drift-file", "textfile", "keyfile"}
+
+// completion holds what the completion scripts offer.
+// Fields:
+// - prog: Name of the command being completed.
+// - all: Every flag, accepted without a command.
+// - flags: The flag names of each command.
+// - values: Flags followed by a value rather than by a server.
+// - servers: Server names offered for the positional arguments.
+type completion struct {
+	prog    string
+	all     *flag.FlagSet
+	flags   map[string][]string
+	values  []string
+	servers []string
+}
+
+// runCompletion writes the completion script for the shell named in args.
+// The servers of -servers-file and NTP_SERVERS are built into the script,
+// a fleet generates it once from its known list of servers.
+func runCompletion(all *flag.FlagSet, args []string) error {
+	serversFile := ""
+	fs := flag.NewFlagSet("timesync completion", flag.ContinueOnError)
+	fs.StringVar(&serversFile, "servers-file", "", "Offer the servers of this file, one per line")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s completion [options] bash|zsh|fish\nOptions:\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := parseFlags(fs, args); errors.Is(err, flag.ErrHelp) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("completion needs one shell: bash, zsh or fish")
+	}
+
+	c := &completion{prog: filepath.Base(os.Args[0]), all: all, flags: map[string][]string{}}
+	if serversFile != "" {
+		servers, err := readServersFile(serversFile)
+		if err != nil {
+			return err
+		}
+		c.servers = servers
+	}
+	c.servers = append(c.servers, envServers()...)
+	// The shells expand the words offered when completing, a name is
+	// built into the script only if it cannot carry any shell syntax.
+	for _, server := range c.servers {
+		if !completableServer(server) {
+			return fmt.Errorf("cannot offer server %q for completion, only host name and address characters are allowed", server)
+		}
+	}
+	c.flags[""] = flagNames(all)
+	for i := range commands {
+		c.flags[commands[i].name] = flagNames(commands[i].flagSet(all))
+	}
+	c.flags["completion"] = flagNames(fs)
+	all.VisitAll(func(f *flag.Flag) {
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); !ok || !b.IsBoolFlag() {
+			c.values = append(c.values, f.Name)
+		}
+	})
+
+	switch fs.Arg(0) {
+	case "bash":
+		c.bash(os.Stdout)
+	case "zsh":
+		c.zsh(os.Stdout)
+	case "fish":
+		c.fish(os.Stdout)
+	default:
+		return fmt.Errorf("unsupported shell %q, want bash, zsh or fish", fs.Arg(0))
+	}
+	return nil
+}
+
+// completableServer reports whether server is made of the characters of a
+// host name, an IP address with its zone, and a port.
+func completableServer(server string) bool {
+	for _, r := range server {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case strings.ContainsRune(".-_:[]%", r):
+		default:
+			return false
+		}
+	}
+	return server != ""
+}
+
+// flagNames returns the flags of fs with their dash, sorted.
+func flagNames(fs *flag.FlagSet) []string {
+	var names []string
+	fs.VisitAll(func(f *flag.Flag) { names = append(names, "-"+f.Name) })
+	return names
+}
+
+// commandNames returns the names of the subcommands.
+func commandNames() []string {
+	var names []string
+	for _, c := range commands {
+		names = append(names, c.name)
+	}
+	return names
+}
+
+// dashed returns names with a dash added in front of each.
+func dashed(names []string) []string {
+	out := make([]string, len(names))
+	for i, name := range names {
+		out[i] = "-" + name
+	}
+	return out
+}
+
+func (c *completion) bash(w io.Writer) {
+	fmt.Fprintf(w, "# bash completion for %s, load with: source <(%s completion bash)\n", c.prog, c.prog)
+	fmt.Fprintln(w, "_timesync() {")
+	fmt.Fprintln(w, "\tlocal cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]} words")
+	fmt.Fprintln(w, "\tCOMPREPLY=()")
+	fmt.Fprintln(w, "\tcase $prev in")
+	fmt.Fprintf(w, "\t%s) COMPREPLY=($(compgen -f -- \"$cur\")); return ;;\n", strings.Join(dashed(fileFlags), "|"))
+	fmt.Fprintf(w, "\t%s) return ;;\n", strings.Join(dashed(c.values), "|"))
+	fmt.Fprintln(w, "\tesac")
+	fmt.Fprintln(w, "\tcase ${COMP_WORDS[1]} in")
+	for _, name := range commandNames() {
+		fmt.Fprintf(w, "\t%s) words=%q ;;\n", name, strings.Join(c.flags[name], " "))
+	}
+	fmt.Fprintf(w, "\t*) words=%q ;;\n", strings.Join(c.flags[""], " "))
+	fmt.Fprintln(w, "\tesac")
+	fmt.Fprintln(w, "\tif [[ ${COMP_WORDS[1]} == completion ]]; then")
+	fmt.Fprintln(w, "\t\twords=\"$words bash zsh fish\"")
+	fmt.Fprintln(w, "\telif [[ ${COMP_WORDS[1]} != version && $cur != -* ]]; then")
+	fmt.Fprintf(w, "\t\twords=%q\n", strings.TrimSpace("$words "+strings.Join(c.servers, " ")))
+	fmt.Fprintln(w, "\t\t((COMP_CWORD == 1)) && words=\"$words "+strings.Join(commandNames(), " ")+"\"")
+	fmt.Fprintln(w, "\tfi")
+	fmt.Fprintln(w, "\tCOMPREPLY=($(compgen -W \"$words\" -- \"$cur\"))")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintf(w, "complete -F _timesync %s\n", c.prog)
+}
+
+func (c *completion) zsh(w io.Writer) {
+	fmt.Fprintf(w, "#compdef %s\n", c.prog)
+	fmt.Fprintf(w, "# zsh completion for %s, load with: source <(%s completion zsh)\n", c.prog, c.prog)
+	fmt.Fprintln(w, "_timesync() {")
+	fmt.Fprintln(w, "\tlocal -a candidates")
+	fmt.Fprintln(w, "\tcase $words[CURRENT-1] in")
+	fmt.Fprintf(w, "\t(%s) _files; return ;;\n", strings.Join(dashed(fileFlags), "|"))
+	fmt.Fprintf(w, "\t(%s) return ;;\n", strings.Join(dashed(c.values), "|"))
+	fmt.Fprintln(w, "\tesac")
+	fmt.Fprintln(w, "\tcase $words[2] in")
+	for _, name := range commandNames() {
+		fmt.Fprintf(w, "\t(%s) candidates=(%s) ;;\n", name, strings.Join(c.flags[name], " "))
+	}
+	fmt.Fprintf(w, "\t(*) candidates=(%s) ;;\n", strings.Join(c.flags[""], " "))
+	fmt.Fprintln(w, "\tesac")
+	fmt.Fprintln(w, "\tif [[ $words[2] == completion ]]; then")
+	fmt.Fprintln(w, "\t\tcandidates+=(bash zsh fish)")
+	fmt.Fprintln(w, "\telif [[ $words[2] != version && $PREFIX != -* ]]; then")
+	fmt.Fprintf(w, "\t\tcandidates+=(%s)\n", strings.Join(c.servers, " "))
+	fmt.Fprintf(w, "\t\t((CURRENT == 2)) && candidates+=(%s)\n", strings.Join(commandNames(), " "))
+	fmt.Fprintln(w, "\tfi")
+	fmt.Fprintln(w, "\tcompadd -- $candidates")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintf(w, "compdef _timesync %s\n", c.prog)
+}
+
+func (c *completion) fish(w io.Writer) {
+	fmt.Fprintf(w, "# fish completion for %s, load with: %s completion fish | source\n", c.prog, c.prog)
+	fmt.Fprintf(w, "complete -c %s -f\n", c.prog)
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "complete -c %s -n '__fish_use_subcommand' -a %s -d %s\n", c.prog, cmd.name, fishQuote(cmd.summary))
+	}
+	fmt.Fprintf(w, "complete -c %s -n '__fish_seen_subcommand_from completion' -a 'bash zsh fish'\n", c.prog)
+	if len(c.servers) > 0 {
+		fmt.Fprintf(w, "complete -c %s -n 'not __fish_seen_subcommand_from version completion' -a %s\n", c.prog, fishQuote(strings.Join(c.servers, " ")))
+	}
+	c.all.VisitAll(func(f *flag.Flag) {
+		// A flag is offered unless a command without it was given.
+		var without []string
+		for _, name := range commandNames() {
+			if !slices.Contains(c.flags[name], "-"+f.Name) {
+				without = append(without, name)
+			}
+		}
+		line := fmt.Sprintf("complete -c %s -o %s -d %s", c.prog, f.Name, fishQuote(f.Usage))
+		if len(without) > 0 {
+			line += fmt.Sprintf(" -n 'not __fish_seen_subcommand_from %s'", strings.Join(without, " "))
+		}
+		if slices.Contains(fileFlags, f.Name) {
+			line += " -r -F"
+		} else if slices.Contains(c.values, f.Name) {
+			line += " -x"
+		}
+		fmt.Fprintln(w, line)
+	})
+}
+
+// fishQuote quotes s for fish, where only \ and ' are special in single
+// quotes.
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
@@ -0,0 +1,265 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// defaultConfigPath is read when -config is not given, if it exists.
+const defaultConfigPath = "/etc/timesync.conf"
+
+// configAliases name the one letter flags in the configuration file, every
+// other key is the name of a flag.
+var configAliases = map[string]string{
+	"timeout":  "t",
+	"retries":  "r",
+	"interval": "i",
+	"port":     "p",
+	"test":     "n",
+	"verbose":  "v",
+	"quiet":    "q",
+	"syslog":   "s",
+	"daemon":   "d",
+	"ipv4":     "4",
+	"ipv6":     "6",
+}
+
+// configValue holds a value of the configuration file.
+// Fields:
+// - line: Line of the key, for the error messages.
+// - values: The value, or the elements of an array.
+// - array: True if the value was an array.
+type configValue struct {
+	line   int
+	values []string
+	array  bool
+}
+
+// loadConfig applies the configuration file at path to the flags of all
+// that are not in set, the flags given on the command line, and returns
+// the servers it lists. An empty path reads defaultConfigPath when it
+// exists. Keys that match no flag are all reported in one error.
+func loadConfig(all *flag.FlagSet, path string, set map[string]bool) ([]string, error) {
+	if path == "" {
+		if _, err := os.Stat(defaultConfigPath); err != nil {
+			return nil, nil
+		}
+		path = defaultConfigPath
+	}
+	keys, err := parseConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var servers []string
+	var unknown []string
+	names := make([]string, 0, len(keys))
+	for key := range keys {
+		names = append(names, key)
+	}
+	slices.Sort(names)
+	for _, key := range names {
+		value := keys[key]
+		if key == "servers" {
+			servers = value.values
+			continue
+		}
+		name := key
+		if alias, ok := configAliases[key]; ok {
+			name = alias
+		}
+		f := all.Lookup(name)
+		if f == nil || len(key) == 1 || slices.Contains([]string{"h", "version", "config", "server"}, name) {
+			unknown = append(unknown, key)
+			continue
+		}
+		if value.array {
+			return nil, fmt.Errorf("%s:%d: %s takes a single value, not an array", path, value.line, key)
+		}
+		if set[name] {
+			continue
+		}
+		if err := all.Set(name, value.values[0]); err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid value %q for %s: %w", path, value.line, value.values[0], key, err)
+		}
+	}
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("%s: unknown keys: %s", path, strings.Join(unknown, ", "))
+	}
+	return servers, nil
+}
+
+// parseConfigFile reads the TOML subset used by the configuration file:
+// key = value lines with comments, where a value is a string, a number, a
+// boolean or an array of them, possibly over several lines. Tables are not
+// supported, the file has a single level of keys.
+func parseConfigFile(path string) (map[string]configValue, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("configuration file %s not found", path)
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	keys := map[string]configValue{}
+	scanner := bufio.NewScanner(f)
+	n := 0
+	for scanner.Scan() {
+		n++
+		line := strings.TrimSpace(stripComment(scanner.Text()))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			return nil, fmt.Errorf("%s:%d: tables are not supported", path, n)
+		}
+		key, raw, ok := strings.Cut(line, "=")
+		key, raw = strings.TrimSpace(key), strings.TrimSpace(raw)
+		if !ok || key == "" || strings.ContainsAny(key, " \t\"'") {
+			return nil, fmt.Errorf("%s:%d: expected key = value", path, n)
+		}
+		if _, dup := keys[key]; dup {
+			return nil, fmt.Errorf("%s:%d: duplicate key %s", path, n, key)
+		}
+		value := configValue{line: n}
+		if strings.HasPrefix(raw, "[") {
+			// An array may go on over the next lines until it is closed.
+			for !strings.HasSuffix(raw, "]") && scanner.Scan() {
+				n++
+				raw += " " + strings.TrimSpace(stripComment(scanner.Text()))
+			}
+			if !strings.HasSuffix(raw, "]") {
+				return nil, fmt.Errorf("%s:%d: unterminated array", path, value.line)
+			}
+			value.array = true
+			for _, elem := range splitArray(raw[1 : len(raw)-1]) {
+				v, err := configScalar(elem)
+				if err != nil {
+					return nil, fmt.Errorf("%s:%d: %s: %w", path, value.line, key, err)
+				}
+				value.values = append(value.values, v)
+			}
+		} else {
+			v, err := configScalar(raw)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %s: %w", path, n, key, err)
+			}
+			value.values = []string{v}
+		}
+		keys[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return keys, nil
+}
+
+// stripComment removes a # comment from line, unless it is within quotes.
+func stripComment(line string) string {
+	var q quoting
+	for i, c := range line {
+		if !q.next(c) && c == '#' {
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// splitArray splits the elements of an array at the commas outside quotes,
+// a trailing comma is allowed.
+func splitArray(s string) []string {
+	var elems []string
+	var q quoting
+	start := 0
+	for i, c := range s {
+		if !q.next(c) && c == ',' {
+			elems = append(elems, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	if last := strings.TrimSpace(s[start:]); last != "" {
+		elems = append(elems, last)
+	}
+	return elems
+}
+
+// quoting follows the TOML strings of a line character by character: basic
+// strings in double quotes with backslash escapes, literal strings in
+// single quotes without.
+type quoting struct {
+	quote   rune
+	escaped bool
+}
+
+// next moves past c and reports whether it belongs to a string, quotes
+// included.
+func (q *quoting) next(c rune) bool {
+	switch {
+	case q.escaped:
+		q.escaped = false
+	case q.quote == '"' && c == '\\':
+		q.escaped = true
+	case q.quote != 0 && c == q.quote:
+		q.quote = 0
+	case q.quote == 0 && (c == '"' || c == '\''):
+		q.quote = c
+	default:
+		return q.quote != 0
+	}
+	return true
+}
+
+// configScalar returns the text of a string, number or boolean value, as a
+// flag would be given it.
+func configScalar(raw string) (string, error) {
+	switch {
+	case strings.HasPrefix(raw, `"`):
+		s, err := strconv.Unquote(raw)
+		if err != nil {
+			return "", fmt.Errorf("invalid string %s", raw)
+		}
+		return s, nil
+	case strings.HasPrefix(raw, "'"):
+		if len(raw) < 2 || !strings.HasSuffix(raw, "'") {
+			return "", fmt.Errorf("invalid string %s", raw)
+		}
+		return raw[1 : len(raw)-1], nil
+	case raw == "true", raw == "false":
+		return raw, nil
+	}
+	number := strings.ReplaceAll(raw, "_", "")
+	if _, err := strconv.ParseFloat(number, 64); err != nil {
+		return "", fmt.Errorf("invalid value %s, strings must be quoted", raw)
+	}
+	return number, nil
+}
@@ -0,0 +1,140 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"js353.com/timesync-mini/pkg/timesync"
+)
+
+// A suspend stops the monotonic clock but not the wall clock. While waiting,
+// the daemon compares both every clockJumpCheck and resyncs at once when they
+// diverged by more than clockJumpThreshold.
+const (
+	clockJumpCheck     = 10 * time.Second
+	clockJumpThreshold = 5 * time.Second
+)
+
+// runDaemon synchronizes every cfg.Interval until SIGINT or SIGTERM is
+// received. A failed sync is logged and retried at the next tick instead of
+// terminating the process. The time spent syncing is deducted from the wait
+// so the schedule does not drift. SIGHUP cuts the wait short and syncs
+// right away, and so does a wall clock jump such as a resume from suspend,
+// the schedule then restarts from that sync. With cfg.HTTPAddr
+// the health and metrics endpoints are served until the daemon exits, the
+// listener is closed before runDaemon returns.
+func runDaemon(cfg *Config, syslogWriter timesync.Notifier) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	health := &healthStatus{interval: cfg.Interval, err: errNoSync, history: newOffsetHistory(cfg.History)}
+	if cfg.HTTPAddr != "" {
+		stopHTTP, err := startHTTP(cfg.HTTPAddr, health)
+		if err != nil {
+			return fmt.Errorf("%w: %w", errDaemonStart, err)
+		}
+		defer stopHTTP()
+	}
+
+	// Under systemd with Type=notify, the service is ready once the clock
+	// was synchronized once.
+	systemd := newNotifier()
+	go systemd.watchdog(ctx)
+	defer systemd.notify("STOPPING=1")
+	ready := false
+
+	slog.Debug("Daemon mode", "interval", cfg.Interval)
+	for {
+		start := time.Now()
+		result, err := syncOnce(ctx, cfg, syslogWriter)
+		if ctx.Err() == nil {
+			n, mean, stddev := health.record(result, err)
+			if err == nil {
+				slog.Info("Offset statistics", "samples", n, "mean", mean, "stddev", stddev)
+			}
+		}
+		if err != nil {
+			slog.Warn("Sync failed, retrying at next interval", "interval", cfg.Interval)
+			systemd.notify("STATUS=Last sync failed: " + err.Error())
+		} else {
+			if !ready {
+				systemd.notify("READY=1")
+				ready = true
+			}
+			systemd.notify(fmt.Sprintf("STATUS=Synchronized with %s, offset %dms", result.Server, result.Offset.Milliseconds()))
+		}
+		wait := cfg.Interval - time.Since(start)
+		if wait < 0 {
+			wait = 0
+		}
+		timer := time.NewTimer(wait)
+		ticker := time.NewTicker(clockJumpCheck)
+		ref := time.Now()
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				ticker.Stop()
+				slog.Info("Signal received, exiting daemon mode")
+				return nil
+			case <-hup:
+				slog.Info("SIGHUP received, syncing now")
+				break wait
+			case now := <-ticker.C:
+				if jump := clockJump(ref, now); jump.Abs() > clockJumpThreshold {
+					slog.Info("Wall clock jumped, syncing now", "jump", jump.Round(time.Second))
+					break wait
+				}
+				ref = now
+			case <-timer.C:
+				break wait
+			}
+		}
+		timer.Stop()
+		ticker.Stop()
+	}
+}
+
+// clockJump returns how much further the wall clock moved than the monotonic
+// clock between ref and now. Both must carry a monotonic reading.
+func clockJump(ref time.Time, now time.Time) time.Duration {
+	return wallJump(ref, now, now.Round(0))
+}
+
+// wallJump returns how much further the wall clock, read as wall at now, moved
+// than the monotonic clock between ref and now.
+func wallJump(ref time.Time, now time.Time, wall time.Time) time.Duration {
+	return wall.Sub(ref.Round(0)) - now.Sub(ref)
+}
@@ -0,0 +1,132 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"js353.com/timesync-mini/pkg/timesync"
+)
+
+// TestDaemonSystemdSequence checks the sd_notify states sent by the daemon
+// for a sync: READY once, then STATUS, then STOPPING when terminated.
+func TestDaemonSystemdSequence(t *testing.T) {
+	t.Setenv("NTP_SERVERS", "")
+	socket := filepath.Join(t.TempDir(), "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", socket)
+	t.Setenv("WATCHDOG_USEC", "")
+
+	server := startMockSNTP(t, mockSNTP{Offset: 3 * time.Second, Stratum: 2})
+	setArgs(t, "-d", "-n", "-r", "1", "-config", os.DevNull, server.Addr())
+	cfg, err := parseConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- runDaemon(cfg, timesync.Discard) }()
+
+	var states []string
+	buf := make([]byte, 256)
+	for len(states) < 3 {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("states so far %q: %v", states, err)
+		}
+		states = append(states, string(buf[:n]))
+		// Terminate once the first sync was reported.
+		if len(states) == 2 {
+			syscall.Kill(os.Getpid(), syscall.SIGTERM)
+		}
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if states[0] != "READY=1" || !strings.HasPrefix(states[1], "STATUS=Synchronized with "+server.Addr()+", offset 3000ms") || states[2] != "STOPPING=1" {
+		t.Errorf("states = %q, want READY=1, STATUS=Synchronized... and STOPPING=1", states)
+	}
+}
+
+// TestClockJump checks the wall clock jump seen by the daemon: ref.Add keeps
+// both clocks in step, the wall reading alone is then shifted as a suspend or
+// a step of the clock would.
+func TestClockJump(t *testing.T) {
+	ref := time.Now()
+	if got := clockJump(ref, ref.Add(clockJumpCheck)); got != 0 {
+		t.Errorf("clockJump without a shift = %v, want 0", got)
+	}
+	tests := []struct {
+		name  string
+		shift time.Duration
+		jump  bool
+	}{
+		{"no shift", 0, false},
+		{"forward under the threshold", clockJumpThreshold - time.Millisecond, false},
+		{"backward under the threshold", -clockJumpThreshold + time.Millisecond, false},
+		{"forward at the threshold", clockJumpThreshold, false},
+		{"backward at the threshold", -clockJumpThreshold, false},
+		{"forward over the threshold", clockJumpThreshold + time.Millisecond, true},
+		{"backward over the threshold", -clockJumpThreshold - time.Millisecond, true},
+		{"resume from suspend", time.Hour, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := ref.Add(clockJumpCheck)
+			got := wallJump(ref, now, now.Round(0).Add(tt.shift))
+			if got != tt.shift {
+				t.Errorf("jump = %v, want %v", got, tt.shift)
+			}
+			if jumped := got.Abs() > clockJumpThreshold; jumped != tt.jump {
+				t.Errorf("jumped = %v, want %v", jumped, tt.jump)
+			}
+		})
+	}
+}
+
+// TestDaemonHTTPInUse checks that a daemon unable to serve -http exits with
+// its documented code instead of the one of invalid options.
+func TestDaemonHTTPInUse(t *testing.T) {
+	t.Setenv("NTP_SERVERS", "")
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	if code := runWith(t, "-d", "-n", "-http", ln.Addr().String(), "127.0.0.1"); code != exitDaemon {
+		t.Errorf("exit code = %d, want %d", code, exitDaemon)
+	}
+}
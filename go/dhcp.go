@@ -0,0 +1,116 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// dhclientLeases are the lease files of ISC dhclient, run directly or by
+// NetworkManager, holding "option ntp-servers a,b;" statements.
+var dhclientLeases = []string{
+	"/var/lib/dhcp/dhclient*.leases",
+	"/var/lib/dhclient/*.lease*",
+	"/var/lib/NetworkManager/dhclient-*.lease",
+}
+
+// keyValueLeases are the lease files of systemd-networkd and of the
+// NetworkManager internal client, holding "NTP=a b" lines.
+var keyValueLeases = []string{
+	"/run/systemd/netif/leases/*",
+	"/var/lib/NetworkManager/internal-*.lease",
+}
+
+// dhcpServers returns the NTP servers handed out with DHCP option 42 found
+// in the known lease files, without duplicates. Unreadable files are
+// skipped, none found gives an empty list.
+func dhcpServers() []string {
+	var servers []string
+	add := func(found []string) {
+		for _, server := range found {
+			if server != "" && !slices.Contains(servers, server) {
+				servers = append(servers, server)
+			}
+		}
+	}
+	for _, pattern := range dhclientLeases {
+		paths, _ := filepath.Glob(pattern)
+		for _, path := range paths {
+			add(parseLeaseFile(path, dhclientNTPServers))
+		}
+	}
+	for _, pattern := range keyValueLeases {
+		paths, _ := filepath.Glob(pattern)
+		for _, path := range paths {
+			add(parseLeaseFile(path, keyValueNTPServers))
+		}
+	}
+	return servers
+}
+
+// parseLeaseFile returns the servers parse extracts from the last line of
+// path it matches, leases are appended so the last one is the current one.
+func parseLeaseFile(path string, parse func(line string) ([]string, bool)) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var servers []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if found, ok := parse(strings.TrimSpace(scanner.Text())); ok {
+			servers = found
+		}
+	}
+	return servers
+}
+
+// dhclientNTPServers parses an "option ntp-servers 10.0.0.1,10.0.0.2;" line.
+func dhclientNTPServers(line string) ([]string, bool) {
+	value, ok := strings.CutPrefix(line, "option ntp-servers ")
+	if !ok {
+		return nil, false
+	}
+	value = strings.TrimSuffix(strings.TrimSpace(value), ";")
+	var servers []string
+	for _, server := range strings.Split(value, ",") {
+		servers = append(servers, strings.TrimSpace(server))
+	}
+	return servers, true
+}
+
+// keyValueNTPServers parses an "NTP=10.0.0.1 10.0.0.2" line.
+func keyValueNTPServers(line string) ([]string, bool) {
+	value, ok := strings.CutPrefix(line, "NTP=")
+	if !ok {
+		return nil, false
+	}
+	return strings.Fields(value), true
+}
@@ -0,0 +1,84 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"js353.com/timesync-mini/pkg/timesync"
+)
+
+// minDriftInterval is the shortest time between two measurements used to
+// estimate the drift, below it the measurement noise dominates.
+const minDriftInterval = 10 * time.Minute
+
+// estimateDrift returns the frequency error of the local clock in ppm from
+// the previous successful sync and the current offset. A positive value
+// means the local clock runs fast. The offset left after the previous sync
+// is 0 if the clock was corrected then, its measured offset otherwise.
+func estimateDrift(prev syncState, offset time.Duration, now time.Time) (float64, bool) {
+	elapsed := now.Sub(prev.LastSuccess)
+	if elapsed < minDriftInterval {
+		return 0, false
+	}
+	residual := time.Duration(prev.OffsetMS * float64(time.Millisecond))
+	if prev.Adjusted {
+		residual = 0
+	}
+	return -float64(offset-residual) / float64(elapsed) * 1e6, true
+}
+
+// updateDrift estimates the drift from the state file, writes it to the
+// drift file in ntpd's driftfile format and, with -drift-correct, feeds it to
+// the kernel frequency correction. It must run before the state file is
+// replaced by the current sync.
+func updateDrift(cfg *Config, result timesync.Result, syslogWriter timesync.Notifier) {
+	prev, err := readState(cfg.StateFile)
+	if err != nil {
+		slog.Debug("No previous sync to estimate drift from", "error", err)
+		return
+	}
+	ppm, ok := estimateDrift(prev, result.Offset, time.Now())
+	if !ok {
+		slog.Debug("Previous sync too recent to estimate drift", "min", minDriftInterval)
+		return
+	}
+	slog.Info("Estimated clock drift", "ppm", fmt.Sprintf("%.3f", ppm))
+	syslogWriter.Info(fmt.Sprintf("Estimated clock drift %.3f ppm", ppm))
+	if err := writeFileAtomic(cfg.DriftFile, []byte(fmt.Sprintf("%.3f\n", ppm))); err != nil {
+		slog.Error("Failed to write drift file", "path", cfg.DriftFile, "error", err)
+		syslogWriter.Err(fmt.Sprintf("Failed to write drift file %s: %v", cfg.DriftFile, err))
+	}
+	if !cfg.DriftCorrect || cfg.Check {
+		return
+	}
+	if err := timesync.AdjustFrequency(-ppm, cfg.Test); err != nil {
+		slog.Error("Failed to correct clock frequency", "error", err)
+		syslogWriter.Err(fmt.Sprintf("Failed to correct clock frequency: %v", err))
+		return
+	}
+	slog.Info("Clock frequency corrected", "ppm", fmt.Sprintf("%.3f", -ppm))
+}
@@ -2,9 +2,9 @@ module js353.com/timesync-mini
 
 go 1.23.4
 
-require github.com/beevik/ntp v1.4.3
-
 require (
-	golang.org/x/net v0.40.0 // indirect
-	golang.org/x/sys v0.33.0 // indirect
+	github.com/beevik/ntp v1.4.3
+	golang.org/x/sys v0.33.0
 )
+
+require golang.org/x/net v0.40.0 // indirect
@@ -0,0 +1,101 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// offsetHistory holds the last offsets measured by the daemon in a ring
+// buffer, to report the stability of the clock.
+// Fields:
+// - offsets: The recorded offsets, oldest first once the buffer is full.
+// - next: Index in offsets the next offset is written to.
+// - full: If true, offsets wrapped around and every slot is in use.
+type offsetHistory struct {
+	offsets []time.Duration
+	next    int
+	full    bool
+}
+
+// newOffsetHistory returns a history keeping the last size offsets.
+func newOffsetHistory(size int) *offsetHistory {
+	return &offsetHistory{offsets: make([]time.Duration, size)}
+}
+
+// add records an offset, replacing the oldest one when the buffer is full.
+func (h *offsetHistory) add(offset time.Duration) {
+	h.offsets[h.next] = offset
+	h.next++
+	if h.next == len(h.offsets) {
+		h.next = 0
+		h.full = true
+	}
+}
+
+// stats returns the number of recorded offsets, their mean and their sample
+// standard deviation, which is zero below two offsets.
+func (h *offsetHistory) stats() (int, time.Duration, time.Duration) {
+	n := h.next
+	if h.full {
+		n = len(h.offsets)
+	}
+	if n == 0 {
+		return 0, 0, 0
+	}
+	var sum float64
+	for _, offset := range h.offsets[:n] {
+		sum += float64(offset)
+	}
+	mean := sum / float64(n)
+	if n < 2 {
+		return n, time.Duration(mean), 0
+	}
+	var squares float64
+	for _, offset := range h.offsets[:n] {
+		squares += (float64(offset) - mean) * (float64(offset) - mean)
+	}
+	return n, time.Duration(mean), time.Duration(math.Sqrt(squares / float64(n-1)))
+}
+
+// writeHistoryMetrics writes the offset statistics of h in the Prometheus
+// exposition format, nothing before the first offset.
+func writeHistoryMetrics(w io.Writer, h *offsetHistory) {
+	n, mean, stddev := h.stats()
+	if n == 0 {
+		return
+	}
+	fmt.Fprintf(w, "# HELP timesync_offset_history_samples Number of offsets in the history.\n")
+	fmt.Fprintf(w, "# TYPE timesync_offset_history_samples gauge\n")
+	fmt.Fprintf(w, "timesync_offset_history_samples %d\n", n)
+	fmt.Fprintf(w, "# HELP timesync_offset_mean_seconds Mean of the offsets in the history.\n")
+	fmt.Fprintf(w, "# TYPE timesync_offset_mean_seconds gauge\n")
+	fmt.Fprintf(w, "timesync_offset_mean_seconds %g\n", mean.Seconds())
+	fmt.Fprintf(w, "# HELP timesync_offset_stddev_seconds Standard deviation of the offsets in the history.\n")
+	fmt.Fprintf(w, "# TYPE timesync_offset_stddev_seconds gauge\n")
+	fmt.Fprintf(w, "timesync_offset_stddev_seconds %g\n", stddev.Seconds())
+}
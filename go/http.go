@@ -0,0 +1,111 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"js353.com/timesync-mini/pkg/timesync"
+)
+
+// errNoSync is reported by /metrics before the first synchronization.
+var errNoSync = errors.New("no synchronization yet")
+
+// healthStatus holds the outcome of the last synchronization for the HTTP
+// endpoints, it is updated by the daemon loop and read by the handlers.
+type healthStatus struct {
+	mu          sync.Mutex
+	interval    time.Duration
+	result      timesync.Result
+	err         error
+	lastSuccess time.Time
+	history     *offsetHistory
+}
+
+// record stores the outcome of a synchronization and returns the offset
+// statistics, see offsetHistory.stats.
+func (h *healthStatus) record(result timesync.Result, err error) (int, time.Duration, time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.result, h.err = result, err
+	if err == nil {
+		h.lastSuccess = time.Now()
+		h.history.add(result.Offset)
+	}
+	return h.history.stats()
+}
+
+// healthz answers 200 when the last successful synchronization is less than
+// two intervals old, 503 otherwise.
+func (h *healthStatus) healthz(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	lastSuccess := h.lastSuccess
+	h.mu.Unlock()
+	if lastSuccess.IsZero() || time.Since(lastSuccess) > 2*h.interval {
+		http.Error(w, "not synchronized", http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
+
+// metrics serves the last result in the Prometheus exposition format.
+func (h *healthStatus) metrics(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writeMetrics(w, h.result, h.err, h.lastSuccess)
+	writeHistoryMetrics(w, h.history)
+}
+
+// startHTTP listens on addr and serves /healthz and /metrics until the
+// returned function is called, which waits for the requests in flight. The
+// listen error is returned so a taken port fails at startup.
+func startHTTP(addr string, h *healthStatus) (func(), error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", h.healthz)
+	mux.HandleFunc("/metrics", h.metrics)
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("HTTP server failed", "error", err)
+		}
+	}()
+	slog.Debug("HTTP server listening", "addr", ln.Addr().String())
+	return func() {
+		sctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(sctx)
+	}, nil
+}
@@ -24,16 +24,31 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log/slog"
-	"log/syslog"
 	"net"
+	"net/netip"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/beevik/ntp"
+	"js353.com/timesync-mini/pkg/timesync"
+)
+
+// Build metadata, set at link time with
+// -ldflags "-X main.version=... -X main.commit=... -X main.date=...".
+var (
+	version = "dev"
+	commit  = "dev"
+	date    = "dev"
 )
 
 // Config holds the settings for the application.
@@ -41,51 +56,430 @@ import (
 // - Servers: A list of NTP servers to synchronize with.
 // - Verbose: If true, enables verbose output.
 // - Test: If true, runs the application in test mode without setting the system time.
-// - TimeoutMS: Timeout in milliseconds for NTP queries.
+// - Timeout: Timeout of each NTP query.
+// - MaxTimeout: Upper bound of Timeout.
+// - ServerTimeouts: Timeouts given with host@timeout, keyed by server.
 // - Retries: Number of retry attempts.
+// - RetryMode: Order of the retries, round-robin or per-server.
+// - MaxQueries: Cap on the NTP packets sent in a run, 0 for none.
 // - UseSyslog: If true, enables syslog logging.
+// - Daemon: If true, keeps running and re-synchronizes every Interval.
+// - Interval: Delay between two synchronizations in daemon mode.
+// - History: Number of offsets kept by the daemon for its statistics.
+// - Deadline: If set, cap of the total runtime of a single run, retries included.
+// - Best: If true, queries all servers concurrently and keeps the lowest roundtrip.
+// - Slew: If true, offsets below StepThreshold are slewed instead of ignored.
+// - StepThreshold: Offsets above this are corrected by stepping the clock.
+// - Source: If set, local IP address the queries are sent from.
+// - IPv4Only: If true, only IPv4 addresses of the servers are queried.
+// - IPv6Only: If true, only IPv6 addresses of the servers are queried.
+// - NoDNS: If true, servers must be IP addresses and nothing is resolved.
+// - DNSServer: If set, names are resolved by this DNS server instead of the system resolver.
+// - JSON: If true, prints the result as a JSON object on stdout.
+// - PrintOffset: If true, prints only the offset in seconds on stdout.
+// - Port: Default NTP port for servers given without one.
+// - NTPVersion: NTP version of the queries, 3 or 4.
+// - KeyFile: ntp.keys style file holding the symmetric authentication keys.
+// - KeyID: Identifier of the key to use from KeyFile.
+// - Auth: Authentication settings loaded from KeyFile, AuthNone if unset.
+// - NTS: If true, servers are authenticated with Network Time Security.
+// - Samples: Number of queries per server, the lowest roundtrip one is used.
+// - KernelTimestamp: If true, the kernel receive time of the answers is used (Linux).
+// - Warmup: If true, a throwaway query precedes the measured one.
+// - Burst: If true, the offsets of the faster half of Samples closely spaced queries are averaged.
+// - FilterK: Offsets further than this many median absolute deviations from the median are dropped.
+// - MaxStratum: If non zero, servers with a higher stratum are rejected.
+// - MaxRootDispersion: If non zero, servers with a higher root dispersion are rejected.
+// - MaxRootDelay: If non zero, servers with a higher root delay are rejected.
+// - MaxJitter: If non zero, servers whose offsets jitter more across the samples are rejected.
+// - MaxRTT: If non zero, a warning is logged for roundtrips above it.
+// - MaxMeasure: Longest time between the clock reads around a query before it is retried.
+// - Asymmetry: How much longer the outbound delay is than the return one.
+// - AsymmetryFraction: If non zero, share of the roundtrip spent on the outbound path.
+// - PanicThreshold: Offsets above this are refused unless Force is set.
+// - Force: If true, offsets above PanicThreshold are applied too, up to a year.
+// - ForceYear: If true, offsets above a year are applied too, implies Force.
+// - SyncRTC: If true, the hardware clock is updated after the system clock is stepped.
+// - Check: If true, only reports the offset and fails when above Threshold.
+// - Threshold: Largest absolute offset accepted in check mode.
+// - SetOnlyIfOff: If true, behaves like Check unless the offset is above StepThreshold, the clock is then stepped.
+// - Compare: If true, only reports the offset of every server and fails when they disagree.
+// - Tolerance: Largest spread between server offsets accepted in compare mode.
+// - Consensus: If true, queries all servers and uses the offset they agree on.
+// - MinAgree: Number of servers that must agree in consensus mode.
+// - Average: If true, queries all servers and applies the mean of their offsets.
+// - Textfile: If set, Prometheus textfile collector output is written there.
+// - ServersFile: File listing additional servers, one per line.
+// - UseNTPConf: If true and no server is given, the servers of the local NTP daemon configuration are used.
+// - UseDHCP: If true, the servers handed out by DHCP are queried first.
+// - Quiet: If true, only errors are logged on stderr.
+// - LogLevel: Minimum level of the messages logged on stderr.
+// - LogFormat: Format of the messages logged on stderr, text or json.
+// - TimeFormat: Layout or keyword of the displayed times, see timeFormatter.
+// - FormatTime: Formats the displayed times after TimeFormat, nil for the defaults.
+// - Location: Time zone of the displayed times, UTC unless -local is given.
+// - SyslogAddr: Remote syslog collector, the local daemon is used if empty.
+// - SyslogProto: Network used to reach SyslogAddr, udp or tcp.
+// - RetryDelay: Delay before the first retry, 0 for none.
+// - BackoffMax: Upper bound of the exponential delay between retries.
+// - Jitter: If true, randomizes the delay between retries.
+// - HTTPAddr: If set, daemon mode serves /healthz and /metrics on this address.
+// - RFC868: If true, the servers are queried with RFC 868 when every NTP query failed.
+// - HTTPFallback: If set, URL whose Date header is used when every NTP query failed.
+// - StateFile: If set, the last successful synchronization is recorded there.
+// - StepSize: Largest single step, larger corrections are split into increments.
+// - NoBackward: If true, the clock is never stepped backwards.
+// - StepCooldown: If set, the clock is not stepped again within this duration of the last step recorded in StateFile.
+// - MinInterval: If set, the run is skipped when StateFile records a success more recent than this.
+// - DriftFile: If set, the clock drift estimated from StateFile is written there.
+// - DriftCorrect: If true, the estimated drift is fed to the kernel frequency correction.
 type Config struct {
-	Servers   []string
-	Verbose   bool
-	Test      bool
-	TimeoutMS int
-	Retries   int
-	UseSyslog bool
+	Servers           []string
+	Verbose           bool
+	Test              bool
+	Timeout           time.Duration
+	MaxTimeout        time.Duration
+	ServerTimeouts    map[string]time.Duration
+	Retries           int
+	RetryMode         string
+	MaxQueries        int
+	UseSyslog         bool
+	Daemon            bool
+	Interval          time.Duration
+	History           int
+	Deadline          time.Duration
+	Best              bool
+	Slew              bool
+	StepThreshold     time.Duration
+	Source            string
+	IPv4Only          bool
+	IPv6Only          bool
+	NoDNS             bool
+	DNSServer         string
+	JSON              bool
+	PrintOffset       bool
+	Port              int
+	NTPVersion        int
+	KeyFile           string
+	KeyID             int
+	Auth              ntp.AuthOptions
+	NTS               bool
+	Samples           int
+	KernelTimestamp   bool
+	Warmup            bool
+	Burst             bool
+	FilterK           float64
+	MaxStratum        int
+	MaxRootDispersion time.Duration
+	MaxRootDelay      time.Duration
+	MaxJitter         time.Duration
+	MaxRTT            time.Duration
+	MaxMeasure        time.Duration
+	Asymmetry         time.Duration
+	AsymmetryFraction float64
+	PanicThreshold    time.Duration
+	Force             bool
+	ForceYear         bool
+	SyncRTC           bool
+	Check             bool
+	Threshold         time.Duration
+	SetOnlyIfOff      bool
+	Compare           bool
+	Tolerance         time.Duration
+	Consensus         bool
+	Average           bool
+	MinAgree          int
+	Textfile          string
+	ServersFile       string
+	UseNTPConf        bool
+	UseDHCP           bool
+	Quiet             bool
+	LogLevel          slog.Level
+	LogFormat         string
+	TimeFormat        string
+	FormatTime        func(time.Time) string
+	Location          *time.Location
+	SyslogAddr        string
+	SyslogProto       string
+	RetryDelay        time.Duration
+	BackoffMax        time.Duration
+	Jitter            bool
+	HTTPAddr          string
+	RFC868            bool
+	HTTPFallback      string
+	StateFile         string
+	StepSize          time.Duration
+	NoBackward        bool
+	StepCooldown      time.Duration
+	MinInterval       time.Duration
+	DriftFile         string
+	DriftCorrect      bool
+}
+
+// msDuration is a flag.Value for durations that also accepts a bare integer
+// as a number of milliseconds, as -t always did.
+type msDuration time.Duration
+
+func (d *msDuration) String() string {
+	return time.Duration(*d).String()
+}
+
+func (d *msDuration) Set(s string) error {
+	if ms, err := strconv.Atoi(s); err == nil {
+		*d = msDuration(time.Duration(ms) * time.Millisecond)
+		return nil
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q, expected milliseconds or a duration like 10s", s)
+	}
+	*d = msDuration(v)
+	return nil
+}
+
+// stringList is a flag.Value collecting the values of a repeated flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// asymmetry is the flag.Value of -asymmetry. A number between 0 and 1 is
+// the share of the roundtrip spent on the outbound path, any other number
+// is a delay difference in milliseconds, like a duration such as 20ms.
+type asymmetry struct {
+	delay    *time.Duration
+	fraction *float64
+}
+
+func (a *asymmetry) String() string {
+	if a.fraction == nil {
+		return ""
+	}
+	if *a.fraction != 0 {
+		return strconv.FormatFloat(*a.fraction, 'g', -1, 64)
+	}
+	if *a.delay != 0 {
+		return a.delay.String()
+	}
+	return ""
+}
+
+func (a *asymmetry) Set(s string) error {
+	*a.delay, *a.fraction = 0, 0
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		if f > 0 && f < 1 {
+			*a.fraction = f
+		} else {
+			*a.delay = time.Duration(f * float64(time.Millisecond))
+		}
+		return nil
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid asymmetry %q, expected a fraction between 0 and 1, milliseconds or a duration", s)
+	}
+	*a.delay = v
+	return nil
+}
+
+// timeFormatter returns the function formatting times after format, one of
+// the keywords rfc3339, rfc3339nano, unix, unixmilli and kitchen, or a Go
+// layout such as "02 Jan 15:04:05".
+func timeFormatter(format string) (func(time.Time) string, error) {
+	switch format {
+	case "rfc3339":
+		return func(t time.Time) string { return t.Format(time.RFC3339) }, nil
+	case "rfc3339nano":
+		return func(t time.Time) string { return t.Format(time.RFC3339Nano) }, nil
+	case "unix":
+		return func(t time.Time) string { return strconv.FormatInt(t.Unix(), 10) }, nil
+	case "unixmilli":
+		return func(t time.Time) string { return strconv.FormatInt(t.UnixMilli(), 10) }, nil
+	case "kitchen":
+		return func(t time.Time) string { return t.Format(time.Kitchen) }, nil
+	}
+	// A layout without any reference element formats to itself, it is
+	// a mistyped keyword.
+	if time.Unix(0, 0).Format(format) == format {
+		return nil, fmt.Errorf("invalid time format %q, expected rfc3339, rfc3339nano, unix, unixmilli, kitchen or a Go layout", format)
+	}
+	return func(t time.Time) string { return t.Format(format) }, nil
 }
 
 func parseConfig() (*Config, error) {
 	cfg := &Config{
-		TimeoutMS: 2000, // default
-		Retries:   3,    // default
+		Timeout:        2000 * time.Millisecond,
+		MaxTimeout:     6000 * time.Millisecond,
+		Retries:        3, // default
+		Interval:       300 * time.Second,
+		StepThreshold:  500 * time.Millisecond,
+		Port:           123,
+		Samples:        1,
+		Threshold:      500 * time.Millisecond,
+		Tolerance:      100 * time.Millisecond,
+		MinAgree:       2,
+		FilterK:        3,
+		PanicThreshold: 1000 * time.Second,
+		RetryDelay:     timesync.DefaultRetryDelay,
+		BackoffMax:     5 * time.Second,
+		MaxMeasure:     timesync.DefaultMaxMeasure,
 	}
 	showHelp := false
+	logLevel := ""
+	showVersion := false
+	local := false
+	configPath := ""
+	var serverFlags stringList
 
-	fs := flag.NewFlagSet("timesync", flag.ExitOnError)
-	fs.IntVar(&cfg.TimeoutMS, "t", 2000, "Timeout in milliseconds (max: 6000)")
+	fs := flag.NewFlagSet("timesync", flag.ContinueOnError)
+	fs.Var((*msDuration)(&cfg.Timeout), "t", "Timeout in milliseconds or as a duration, e.g. 10s (max: -max-timeout)")
+	fs.Var((*msDuration)(&cfg.MaxTimeout), "max-timeout", "Cap of -t, raise it for high latency links")
 	fs.IntVar(&cfg.Retries, "r", 3, "Number of retries (max: 10)")
+	fs.IntVar(&cfg.MaxQueries, "max-queries", 0, "Maximum number of NTP packets sent in a run, whatever the retries and servers (0: no limit)")
+	fs.StringVar(&cfg.RetryMode, "retry-mode", "round-robin", "Retry order: round-robin (passes over all servers) or per-server (retries on one server before the next)")
 	fs.BoolVar(&cfg.Test, "n", false, "Run in test mode (no action)")
 	fs.BoolVar(&cfg.Verbose, "v", false, "Verbose output")
 	fs.BoolVar(&cfg.UseSyslog, "s", false, "Enable syslog logging")
+	fs.BoolVar(&cfg.Daemon, "d", false, "Daemon mode, re-sync every interval until killed")
+	fs.DurationVar(&cfg.Interval, "i", 300*time.Second, "Interval between syncs in daemon mode")
+	fs.IntVar(&cfg.History, "history", 64, "Number of offsets kept for the mean and standard deviation in daemon mode")
+	fs.DurationVar(&cfg.Deadline, "deadline", 0, "Give up after this total time across all retries and servers, e.g. 10s (not with -d)")
+	fs.BoolVar(&cfg.Best, "best", false, "Query all servers concurrently and use the lowest roundtrip")
+	fs.BoolVar(&cfg.Slew, "slew", false, "Slew offsets below the step threshold instead of ignoring them (Linux)")
+	fs.DurationVar(&cfg.StepThreshold, "step-threshold", 500*time.Millisecond, "Offsets above this step the clock")
+	fs.DurationVar(&cfg.StepThreshold, "min-adjust", 500*time.Millisecond, "Same as -step-threshold, the clock is already in sync below it")
+	fs.StringVar(&cfg.Source, "source", "", "Send the queries from this local IP address, e.g. on a management interface")
+	fs.BoolVar(&cfg.IPv4Only, "4", false, "Use IPv4 addresses only")
+	fs.BoolVar(&cfg.IPv6Only, "6", false, "Use IPv6 addresses only")
+	fs.BoolVar(&cfg.NoDNS, "no-dns", false, "Only accept IP addresses as servers, never use DNS")
+	fs.StringVar(&cfg.DNSServer, "dns-server", "", "Resolve names with this DNS server ip[:port] instead of the system resolver")
+	fs.BoolVar(&cfg.JSON, "json", false, "Print the result as JSON on stdout")
+	fs.BoolVar(&cfg.PrintOffset, "print-offset", false, "Print only the offset in seconds on stdout, e.g. -0.042300")
+	fs.IntVar(&cfg.Port, "p", 123, "Default NTP port for servers given without host:port")
+	fs.IntVar(&cfg.NTPVersion, "ntp-version", 4, "NTP version of the queries, 3 for legacy servers or 4")
+	fs.StringVar(&cfg.KeyFile, "keyfile", "", "ntp.keys file for symmetric key authentication")
+	fs.IntVar(&cfg.KeyID, "keyid", 0, "Key id to use from the key file")
+	fs.BoolVar(&cfg.NTS, "nts", false, "Authenticate servers with NTS, the server port is the NTS-KE one (default 4460)")
+	fs.IntVar(&cfg.Samples, "samples", 1, "Number of samples per server, the lowest roundtrip wins (max: 16)")
+	fs.BoolVar(&cfg.KernelTimestamp, "kernel-timestamp", false, "Use the kernel receive time of the answers (SO_TIMESTAMPNS, Linux), more precise under load")
+	fs.BoolVar(&cfg.Warmup, "warmup", false, "Discard a first query that warms the DNS and socket caches, then measure")
+	fs.BoolVar(&cfg.Burst, "burst", false, "Average the faster half of a burst of -samples queries (default 8)")
+	fs.Float64Var(&cfg.FilterK, "filter-k", 3, "Drop offsets more than k median absolute deviations from the median (-burst, -best)")
+	fs.IntVar(&cfg.MaxStratum, "max-stratum", 0, "Reject servers above this stratum (0: no limit)")
+	fs.DurationVar(&cfg.MaxRootDispersion, "max-root-dispersion", 0, "Reject servers above this root dispersion (0: no limit)")
+	fs.DurationVar(&cfg.MaxRootDelay, "max-root-delay", 0, "Reject servers above this root delay (0: no limit)")
+	fs.DurationVar(&cfg.MaxJitter, "max-jitter", 0, "Reject servers whose offset jitter across -samples or -burst is above this (0: no limit)")
+	fs.DurationVar(&cfg.MaxRTT, "max-rtt", 0, "Warn when the roundtrip is above this (0: never)")
+	fs.Var((*msDuration)(&cfg.MaxMeasure), "max-measure-ms", "Retry a query whose clock reads are further apart, in milliseconds or as a duration")
+	fs.Var(&asymmetry{&cfg.Asymmetry, &cfg.AsymmetryFraction}, "asymmetry", "Correct an asymmetric path: outbound share of the roundtrip between 0 and 1, or outbound minus return delay in ms or as a duration")
+	fs.DurationVar(&cfg.PanicThreshold, "panic-threshold", 1000*time.Second, "Refuse to adjust the clock by more than this")
+	fs.BoolVar(&cfg.Force, "force", false, "Adjust the clock even above the panic threshold, up to a year")
+	fs.BoolVar(&cfg.ForceYear, "force-year", false, "Adjust the clock even by more than a year, e.g. from 1970 on a device without RTC (implies -force)")
+	fs.BoolVar(&cfg.SyncRTC, "sync-rtc", false, "Write the system time to the hardware clock after stepping it (Linux)")
+	fs.BoolVar(&cfg.Check, "check", false, "Only report offset, rtt and stratum, exit 1 if the offset is above the threshold")
+	fs.DurationVar(&cfg.Threshold, "threshold", 500*time.Millisecond, "Largest absolute offset accepted by -check")
+	fs.BoolVar(&cfg.SetOnlyIfOff, "set-only-if-off", false, "Only report like -check unless the offset is above the step threshold, then step the clock")
+	fs.BoolVar(&cfg.Compare, "compare", false, "Only print the offset of every server, exit 1 if they disagree by more than the tolerance")
+	fs.DurationVar(&cfg.Tolerance, "tolerance", 100*time.Millisecond, "Largest spread between server offsets accepted by -compare")
+	fs.BoolVar(&cfg.Consensus, "consensus", false, "Query all servers and use the offset a quorum agrees on")
+	fs.IntVar(&cfg.MinAgree, "min-agree", 2, "Number of servers that must agree with -consensus")
+	fs.BoolVar(&cfg.Average, "average", false, "Query all servers and apply the mean of their offsets, outliers dropped")
+	fs.StringVar(&cfg.Textfile, "textfile", "", "Write Prometheus metrics to this file for the node_exporter textfile collector")
+	fs.Var(&serverFlags, "server", "NTP server host[:port], may be repeated, added after the positional servers")
+	fs.StringVar(&cfg.ServersFile, "servers-file", "", "Read servers from this file, one per line")
+	fs.BoolVar(&cfg.UseDHCP, "use-dhcp", false, "Query the NTP servers from the DHCP leases first (Linux)")
+	fs.BoolVar(&cfg.UseNTPConf, "use-ntpconf", false, "Without servers, use those of /etc/ntp.conf or chrony.conf")
+	fs.BoolVar(&cfg.Quiet, "q", false, "Quiet, only log errors (syslog still records everything)")
+	fs.BoolVar(&cfg.Quiet, "quiet", false, "Same as -q")
+	fs.StringVar(&logLevel, "log-level", "info", "Log level: debug, info, warn or error (-v is debug)")
+	fs.StringVar(&cfg.LogFormat, "log-format", "text", "Log format on stderr: text or json")
+	fs.BoolVar(&local, "local", false, "Display times in the local time zone instead of UTC")
+	fs.StringVar(&cfg.TimeFormat, "time-format", "", "Format of the displayed times: rfc3339, rfc3339nano, unix, unixmilli, kitchen or a Go layout")
+	fs.StringVar(&cfg.SyslogAddr, "syslog-addr", "", "Send syslog to this host:port instead of the local daemon (implies -s)")
+	fs.StringVar(&cfg.SyslogProto, "syslog-proto", "udp", "Network for -syslog-addr: udp or tcp")
+	fs.DurationVar(&cfg.RetryDelay, "retry-delay", timesync.DefaultRetryDelay, "Delay before the first retry, doubled after each failure, 0 for none")
+	fs.DurationVar(&cfg.BackoffMax, "backoff-max", 5*time.Second, "Cap of the exponential delay between retries")
+	fs.BoolVar(&cfg.Jitter, "jitter", false, "Randomize the delay between retries")
+	fs.StringVar(&cfg.HTTPAddr, "http", "", "Serve /healthz and /metrics on this address in daemon mode, e.g. :8080")
+	fs.BoolVar(&cfg.RFC868, "rfc868", false, "Fall back to the RFC 868 Time Protocol (TCP/37) when every NTP query failed")
+	fs.StringVar(&cfg.HTTPFallback, "http-fallback", "", "Use the Date header of this HTTPS URL when every NTP query failed (~1s precision)")
+	fs.StringVar(&cfg.StateFile, "state-file", "", "Record the last successful sync in this JSON file")
+	fs.DurationVar(&cfg.StepSize, "step-size", 0, "Step large offsets in increments of at most this size, 200ms apart (0: one step)")
+	fs.BoolVar(&cfg.NoBackward, "no-backward", false, "Never step the clock backwards, slew such offsets with -slew or only report them")
+	fs.DurationVar(&cfg.StepCooldown, "step-cooldown", 0, "Refuse to step the clock again within this duration of the last step (needs -state-file)")
+	fs.DurationVar(&cfg.MinInterval, "min-interval", 0, "Exit at once if the state file records a successful sync within this duration (needs -state-file)")
+	fs.StringVar(&cfg.DriftFile, "drift-file", "", "Write the clock drift in ppm estimated between runs to this file (needs -state-file)")
+	fs.BoolVar(&cfg.DriftCorrect, "drift-correct", false, "Correct the clock frequency with the estimated drift (Linux, needs -drift-file)")
+	fs.StringVar(&configPath, "config", "", "Read settings from this TOML file, the command line overrides it (default "+defaultConfigPath+" if present)")
+	fs.BoolVar(&showVersion, "version", false, "Print version information and exit")
 	fs.BoolVar(&showHelp, "h", false, "Display usage")
 	// Override the default usage message to include the ntp server argument.
 	fs.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: %s [options] <ntp-server>\nOptions:\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage: %s [command] [options] <ntp-server[:port]>\n%sOptions:\n", os.Args[0], commandsUsage())
 		fs.PrintDefaults()
+		fmt.Fprint(os.Stderr, exitCodesUsage)
 	}
 	fs.SetOutput(os.Stderr)
-	fs.Parse(os.Args[1:])
+	// A subcommand only accepts its own flags, the flag-only form of older
+	// releases still accepts them all.
+	cmd, args := findCommand(os.Args[1:])
+	if cmd != nil && cmd.name == "completion" {
+		return nil, runCompletion(fs, args)
+	}
+	all := fs
+	if cmd != nil {
+		fs = cmd.flagSet(all)
+	}
+	if err := parseFlags(fs, args); errors.Is(err, flag.ErrHelp) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
 	if showHelp {
-		fmt.Fprintf(os.Stderr, "Usage: %s [options] <ntp-server>\nOptions:\n", os.Args[0])
-		fs.PrintDefaults()
+		fs.Usage()
+		return nil, nil
+	}
+	if cmd != nil && cmd.apply == nil {
+		showVersion = true
+		if fs.NArg() > 0 {
+			return nil, fmt.Errorf("%s takes no argument", cmd.name)
+		}
+	}
+	if showVersion {
+		fmt.Printf("timesync %s (commit %s, built %s)\n", version, commit, date)
 		return nil, nil
 	}
+	// The command line takes precedence over the environment, which takes
+	// precedence over the configuration file.
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	configServers, err := loadConfig(all, configPath, set)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.applyEnv(set); err != nil {
+		return nil, err
+	}
+	if cmd != nil {
+		cmd.apply(cfg)
+	}
 
 	// Validate and clamp timeout
-	if cfg.TimeoutMS > 6000 {
-		cfg.TimeoutMS = 6000
+	if cfg.MaxTimeout <= 0 {
+		cfg.MaxTimeout = 6000 * time.Millisecond
+	}
+	if cfg.Timeout > cfg.MaxTimeout {
+		slog.Warn("Timeout above the cap, clamped", "timeout", cfg.Timeout, "max", cfg.MaxTimeout)
+		cfg.Timeout = cfg.MaxTimeout
 	}
-	if cfg.TimeoutMS <= 0 {
-		cfg.TimeoutMS = 2000
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 2000 * time.Millisecond
 	}
 
 	// Validate and clamp retries
@@ -95,180 +489,713 @@ func parseConfig() (*Config, error) {
 	if cfg.Retries <= 0 {
 		cfg.Retries = 3
 	}
+	if cfg.MaxQueries < 0 {
+		return nil, errors.New("-max-queries must not be negative")
+	}
+	switch timesync.RetryMode(cfg.RetryMode) {
+	case timesync.RetryRoundRobin, timesync.RetryPerServer:
+	default:
+		return nil, fmt.Errorf("invalid -retry-mode %q, want round-robin or per-server", cfg.RetryMode)
+	}
+
+	// Validate and clamp samples
+	if cfg.Samples > 16 {
+		cfg.Samples = 16
+	}
+	if cfg.Samples <= 0 {
+		cfg.Samples = 1
+	}
+	// The jitter is measured between samples of the same server.
+	if cfg.MaxJitter < 0 {
+		return nil, errors.New("-max-jitter must not be negative")
+	}
+	if cfg.MaxJitter > 0 && cfg.Samples < 2 && !cfg.Burst {
+		return nil, errors.New("-max-jitter needs -samples above 1 or -burst")
+	}
+
+	// Validate interval
+	if cfg.Interval <= 0 {
+		cfg.Interval = 300 * time.Second
+	}
+
+	// Correcting only large offsets contradicts slewing the small ones.
+	if cfg.SetOnlyIfOff && (cfg.Slew || cfg.Check || cfg.Compare || cfg.Daemon) {
+		return nil, errors.New("-set-only-if-off cannot be combined with -slew, -check, -compare or -d")
+	}
+
+	// A one-shot report, the daemon would be silently dropped.
+	if (cfg.Check || cfg.Compare) && cfg.Daemon {
+		return nil, errors.New("-check and -compare cannot be combined with -d")
+	}
+
+	// Validate history
+	if cfg.History <= 0 {
+		return nil, fmt.Errorf("invalid history size %d", cfg.History)
+	}
+
+	// Validate deadline, the daemon never finishes
+	if cfg.Deadline < 0 {
+		return nil, fmt.Errorf("invalid deadline %v", cfg.Deadline)
+	}
+	if cfg.Deadline > 0 && cfg.Daemon {
+		return nil, errors.New("-deadline cannot be combined with -d")
+	}
+
+	// Validate backoff, never below the first retry delay
+	if cfg.RetryDelay < 0 {
+		return nil, fmt.Errorf("invalid retry delay %v", cfg.RetryDelay)
+	}
+	if cfg.BackoffMax < cfg.RetryDelay {
+		cfg.BackoffMax = cfg.RetryDelay
+	}
+
+	if cfg.HTTPAddr != "" && !cfg.Daemon {
+		return nil, errors.New("-http requires -d")
+	}
+
+	if cfg.HTTPFallback != "" {
+		if u, err := url.Parse(cfg.HTTPFallback); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return nil, fmt.Errorf("invalid fallback URL %q", cfg.HTTPFallback)
+		}
+	}
+
+	if cfg.StepCooldown < 0 {
+		return nil, fmt.Errorf("invalid step cooldown %v", cfg.StepCooldown)
+	}
+	if cfg.StepCooldown > 0 && cfg.StateFile == "" {
+		return nil, errors.New("-step-cooldown requires -state-file")
+	}
+	if cfg.MinInterval < 0 {
+		return nil, fmt.Errorf("invalid minimum interval %v", cfg.MinInterval)
+	}
+	if cfg.MinInterval > 0 && (cfg.StateFile == "" || cfg.Daemon || cfg.Check || cfg.Compare) {
+		return nil, errors.New("-min-interval requires -state-file and cannot be combined with -d, -check or -compare")
+	}
+	if cfg.DriftFile != "" && cfg.StateFile == "" {
+		return nil, errors.New("-drift-file requires -state-file")
+	}
+	if cfg.DriftCorrect && cfg.DriftFile == "" {
+		return nil, errors.New("-drift-correct requires -drift-file")
+	}
+
+	if cfg.PanicThreshold <= 0 {
+		return nil, fmt.Errorf("invalid panic threshold %v", cfg.PanicThreshold)
+	}
+
+	if cfg.StepSize < 0 {
+		return nil, fmt.Errorf("invalid step size %v, must not be negative", cfg.StepSize)
+	}
+
+	if cfg.StepThreshold <= 0 {
+		return nil, fmt.Errorf("invalid step threshold %v, must be positive", cfg.StepThreshold)
+	}
+
+	if cfg.MaxStratum < 0 || cfg.MaxStratum > 15 {
+		return nil, fmt.Errorf("invalid maximum stratum %d (0-15)", cfg.MaxStratum)
+	}
+
+	if cfg.MaxMeasure <= 0 {
+		return nil, fmt.Errorf("invalid measurement window %v", cfg.MaxMeasure)
+	}
+	if cfg.MaxRootDispersion < 0 || cfg.MaxRootDelay < 0 || cfg.MaxRTT < 0 {
+		return nil, errors.New("-max-root-dispersion, -max-root-delay and -max-rtt must not be negative")
+	}
+
+	if cfg.FilterK <= 0 {
+		return nil, fmt.Errorf("invalid filter k %v", cfg.FilterK)
+	}
+
+	if cfg.MinAgree <= 0 {
+		return nil, fmt.Errorf("invalid minimum agreement %d", cfg.MinAgree)
+	}
+	if (cfg.Consensus && cfg.Best) || (cfg.Average && (cfg.Best || cfg.Consensus)) {
+		return nil, errors.New("-consensus, -average and -best are mutually exclusive")
+	}
+
+	if cfg.Threshold <= 0 {
+		return nil, fmt.Errorf("invalid threshold %v", cfg.Threshold)
+	}
+	if cfg.Tolerance <= 0 {
+		return nil, fmt.Errorf("invalid tolerance %v", cfg.Tolerance)
+	}
+
+	if cfg.Port <= 0 || cfg.Port > 65535 {
+		return nil, fmt.Errorf("invalid port %d", cfg.Port)
+	}
+	if cfg.NTPVersion != 3 && cfg.NTPVersion != 4 {
+		return nil, fmt.Errorf("invalid NTP version %d, want 3 or 4", cfg.NTPVersion)
+	}
+
+	// Load the authentication key, both flags go together
+	if cfg.KeyFile != "" || cfg.KeyID != 0 {
+		if cfg.KeyFile == "" || cfg.KeyID < 1 || cfg.KeyID > 65535 {
+			return nil, errors.New("-keyfile and -keyid (1-65535) must be given together")
+		}
+		auth, err := timesync.LoadAuthKey(cfg.KeyFile, cfg.KeyID)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Auth = auth
+	}
+
+	// NTS fails closed, an unauthenticated fallback would defeat it.
+	if cfg.NTS && (cfg.KeyFile != "" || cfg.RFC868 || cfg.HTTPFallback != "") {
+		return nil, errors.New("-nts cannot be combined with -keyfile, -rfc868 or -http-fallback")
+	}
+	// NTS extension fields only exist in NTPv4.
+	if cfg.NTS && cfg.NTPVersion != 4 {
+		return nil, errors.New("-nts requires -ntp-version 4")
+	}
+
+	if cfg.DNSServer != "" {
+		if cfg.NoDNS {
+			return nil, errors.New("-dns-server and -no-dns are mutually exclusive")
+		}
+		host, port, err := net.SplitHostPort(cfg.DNSServer)
+		if err != nil {
+			host, port = cfg.DNSServer, "53"
+		}
+		if net.ParseIP(host) == nil {
+			return nil, fmt.Errorf("invalid DNS server %q, expected an IP address", cfg.DNSServer)
+		}
+		cfg.DNSServer = net.JoinHostPort(host, port)
+	}
+
+	if cfg.JSON && cfg.PrintOffset {
+		return nil, errors.New("-json and -print-offset are mutually exclusive")
+	}
+
+	// A source address only reaches servers of its own family.
+	if cfg.Source != "" {
+		// A link-local source needs its zone, e.g. fe80::2%eth0.
+		ip, err := netip.ParseAddr(cfg.Source)
+		if err != nil {
+			return nil, fmt.Errorf("invalid source address %q, expected an IP address", cfg.Source)
+		}
+		if ip.Unmap().Is4() {
+			cfg.IPv4Only = true
+		} else {
+			cfg.IPv6Only = true
+		}
+	}
+
+	if cfg.IPv4Only && cfg.IPv6Only {
+		return nil, errors.New("-4 and -6 are mutually exclusive, or do not match the -source family")
+	}
+
+	// -v is a shortcut for -log-level debug, and debug enables the verbose
+	// output of the timesync package.
+	switch strings.ToLower(logLevel) {
+	case "debug":
+		cfg.LogLevel = slog.LevelDebug
+	case "info":
+		cfg.LogLevel = slog.LevelInfo
+	case "warn":
+		cfg.LogLevel = slog.LevelWarn
+	case "error":
+		cfg.LogLevel = slog.LevelError
+	default:
+		return nil, fmt.Errorf("invalid log level %q (debug, info, warn, error)", logLevel)
+	}
+	if cfg.Quiet && cfg.Verbose {
+		return nil, errors.New("-q and -v are mutually exclusive")
+	}
+	if cfg.Verbose {
+		cfg.LogLevel = slog.LevelDebug
+	}
+	if cfg.Quiet {
+		cfg.LogLevel = slog.LevelError
+	}
+	cfg.Verbose = cfg.LogLevel <= slog.LevelDebug
+
+	// Only the display changes, the clock is always set to an absolute
+	// instant.
+	cfg.Location = time.UTC
+	if local {
+		cfg.Location = time.Local
+	}
+	if cfg.TimeFormat != "" {
+		format, err := timeFormatter(cfg.TimeFormat)
+		if err != nil {
+			return nil, err
+		}
+		cfg.FormatTime = format
+	}
+
+	if cfg.LogFormat != "text" && cfg.LogFormat != "json" {
+		return nil, fmt.Errorf("invalid log format %q (text, json)", cfg.LogFormat)
+	}
+
+	if cfg.SyslogProto != "udp" && cfg.SyslogProto != "tcp" {
+		return nil, fmt.Errorf("invalid syslog protocol %q (udp, tcp)", cfg.SyslogProto)
+	}
+	if cfg.SyslogAddr != "" {
+		cfg.UseSyslog = true
+	}
 
 	// Disable syslog in test mode
 	if cfg.Test {
 		cfg.UseSyslog = false
 	}
 
-	// Check if the NTP server is provided as a positional argument.
-	args := fs.Args()
-	if len(args) == 0 {
+	// Servers come from the first source giving any, in order: the
+	// positional arguments, the -server flags and the servers file, then
+	// NTP_SERVERS, then the configuration file, then the NTP daemon
+	// configuration with -use-ntpconf, and pool.ntp.org last. Servers from
+	// DHCP leases are queried before all of them.
+	cfg.Servers = append(fs.Args(), serverFlags...)
+	if cfg.ServersFile != "" {
+		servers, err := readServersFile(cfg.ServersFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Servers = append(cfg.Servers, servers...)
+	}
+	if len(cfg.Servers) == 0 {
+		cfg.Servers = envServers()
+	}
+	if len(cfg.Servers) == 0 {
+		cfg.Servers = configServers
+	}
+	if len(cfg.Servers) == 0 && cfg.UseNTPConf {
+		servers, path, err := readNTPConf()
+		if err != nil {
+			return nil, err
+		}
+		if len(servers) == 0 {
+			return nil, fmt.Errorf("%s: no server or pool directive", path)
+		}
+		slog.Debug("Using servers from NTP configuration", "path", path, "servers", servers)
+		cfg.Servers = servers
+	}
+	if cfg.UseDHCP {
+		servers := dhcpServers()
+		if len(servers) == 0 {
+			slog.Warn("No NTP server found in the DHCP leases")
+		}
+		cfg.Servers = append(servers, cfg.Servers...)
+	}
+	if len(cfg.Servers) == 0 {
 		cfg.Servers = []string{"pool.ntp.org"}
-	} else {
-		cfg.Servers = args
+	}
+	if err := cfg.splitServerTimeouts(); err != nil {
+		return nil, err
+	}
+	if cfg.NoDNS {
+		if err := cfg.checkNoDNS(); err != nil {
+			return nil, err
+		}
 	}
 	return cfg, nil
 }
 
+// parseFlags parses args with fs, which reports a bad flag with the usage.
+// The flag sets do not use flag.ExitOnError, its exit status 2 would read
+// as a DNS failure, the error is errUsage instead. -help, already answered
+// with the usage, is flag.ErrHelp.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	err := fs.Parse(args)
+	if err != nil && !errors.Is(err, flag.ErrHelp) {
+		return errUsage
+	}
+	return err
+}
+
+// checkNoDNS fails unless every server, and the -http-fallback URL, names
+// its host by IP address.
+func (cfg *Config) checkNoDNS() error {
+	for _, server := range cfg.Servers {
+		host, _, err := net.SplitHostPort(server)
+		if err != nil {
+			host = server
+		}
+		if _, err := netip.ParseAddr(host); err != nil {
+			return fmt.Errorf("-no-dns: server %q is not an IP address", server)
+		}
+	}
+	if cfg.HTTPFallback != "" {
+		u, err := url.Parse(cfg.HTTPFallback)
+		if err != nil {
+			return fmt.Errorf("-no-dns: invalid fallback URL %q", cfg.HTTPFallback)
+		}
+		if _, err := netip.ParseAddr(u.Hostname()); err != nil {
+			return fmt.Errorf("-no-dns: fallback URL %q does not use an IP address", cfg.HTTPFallback)
+		}
+	}
+	return nil
+}
+
+// splitServerTimeouts strips the host[:port]@timeout suffix off the servers
+// and records it in ServerTimeouts. The timeout is in milliseconds or a
+// duration, like -t, and capped by -max-timeout.
+func (cfg *Config) splitServerTimeouts() error {
+	for i, server := range cfg.Servers {
+		at := strings.LastIndex(server, "@")
+		if at < 0 {
+			continue
+		}
+		var timeout msDuration
+		if err := timeout.Set(server[at+1:]); err != nil || timeout <= 0 {
+			return fmt.Errorf("invalid timeout in server %q", server)
+		}
+		cfg.Servers[i] = server[:at]
+		t := time.Duration(timeout)
+		if t > cfg.MaxTimeout {
+			slog.Warn("Server timeout above the cap, clamped", "server", server[:at], "timeout", t, "max", cfg.MaxTimeout)
+			t = cfg.MaxTimeout
+		}
+		if cfg.ServerTimeouts == nil {
+			cfg.ServerTimeouts = map[string]time.Duration{}
+		}
+		cfg.ServerTimeouts[server[:at]] = t
+	}
+	return nil
+}
+
+// readServersFile returns the servers listed in path, one per line. Blank
+// lines and everything after a '#' are ignored.
+func readServersFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var servers []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		if line = strings.TrimSpace(line); line != "" {
+			servers = append(servers, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return servers, nil
+}
+
+// dnsResolver returns a resolver sending every query to addr, bypassing
+// /etc/resolv.conf and the system resolver.
+func dnsResolver(addr string) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+}
+
+// Exit codes, monitoring scripts rely on them to tell a network problem from
+// a clock that could not be set. Invalid options exit with 255.
+const (
+	exitOK         = 0
+	exitOffset     = 1 // -check or -compare only, offset or spread above the limit
+	exitDNS        = 2
+	exitQuery      = 3
+	exitRejected   = 4
+	exitPermission = 5
+	exitPanic      = 6
+	exitDaemon     = 7 // -d only, the daemon could not start
+	exitUsage      = 255
+)
+
+// errUsage is returned for a command line the flag package rejected, it
+// already reported the error and printed the usage.
+var errUsage = errors.New("invalid command line")
+
+// errDaemonStart is returned when the daemon could not start, such as an
+// -http address already in use.
+var errDaemonStart = errors.New("cannot start the daemon")
+
+const exitCodesUsage = `Exit codes:
+  0	success
+  1	offset above -threshold (-check), spread above -tolerance (-compare)
+  2	DNS resolution failed
+  3	NTP query failed or timed out, or -deadline exceeded
+  4	answer rejected by a sanity check (year, stratum, ...)
+  5	permission denied setting the clock
+  6	offset above -panic-threshold, clock left alone
+  7	daemon could not start (-d), e.g. -http address in use
+  255	invalid options
+`
+
+// exitCode maps the error of a synchronization to the exit code of its
+// failure class, from the error values of the timesync package. The order
+// matters, a rejected answer may come with the failures of other servers.
+func exitCode(err error) int {
+	var dnsErr *net.DNSError
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, errDaemonStart):
+		return exitDaemon
+	case errors.Is(err, timesync.ErrPermission):
+		return exitPermission
+	case errors.Is(err, timesync.ErrPanic):
+		return exitPanic
+	case errors.Is(err, timesync.ErrRejected):
+		// ErrBadYear, ErrStratum and the other sanity checks.
+		return exitRejected
+	case errors.Is(err, context.DeadlineExceeded):
+		// -deadline, even when it expired during a lookup.
+		return exitQuery
+	case errors.As(err, &dnsErr):
+		return exitDNS
+	case errors.Is(err, timesync.ErrQueryFailed), errors.Is(err, timesync.ErrSlowMeasurement):
+		return exitQuery
+	default:
+		return exitQuery
+	}
+}
+
 func main() {
+	os.Exit(run())
+}
+
+// run does the work of main and returns the exit code, so that deferred
+// cleanups such as flushing syslog happen before the process exits.
+func run() int {
 	cfg, err := parseConfig()
 
+	if errors.Is(err, errUsage) {
+		return exitUsage
+	}
 	if err != nil {
-		os.Exit(-1)
+		fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+		return exitUsage
 	}
 	if cfg == nil {
-		os.Exit(0)
+		return 0
+	}
+
+	if cfg.LogFormat == "json" {
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: cfg.LogLevel})))
+	} else {
+		slog.SetLogLoggerLevel(cfg.LogLevel)
 	}
 
-	var syslogWriter *syslog.Writer
+	syslogWriter := timesync.Discard
 	if cfg.UseSyslog {
-		syslogWriter, err = syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, "ntp_client")
+		w, err := openSyslog(cfg)
 		if err != nil {
-			slog.Error("Failed to create syslog, ignored", "error", err)
+			slog.Warn("Syslog unavailable, continuing without it", "error", err)
 		} else {
 			slog.Debug("Syslog created")
+			syslogWriter = w
+			defer closeNotifier(w)
+		}
+	}
+
+	// Every lookup goes through the default resolver, the NTP servers as
+	// well as the fallbacks and the NTS key exchange.
+	if cfg.DNSServer != "" {
+		net.DefaultResolver = dnsResolver(cfg.DNSServer)
+	}
+
+	if cfg.StateFile != "" {
+		logStateAge(cfg.StateFile)
+	}
+	// Boot scripts and cron may both run the tool, the pool servers need
+	// not be queried twice in a row.
+	if cfg.MinInterval > 0 {
+		if ago, ok := syncedWithin(cfg.StateFile, cfg.MinInterval); ok {
+			slog.Info(fmt.Sprintf("Skipped: synced %v ago", ago.Round(time.Second)), "min_interval", cfg.MinInterval)
+			syslogWriter.Info(fmt.Sprintf("Skipped: synced %v ago, within %v", ago.Round(time.Second), cfg.MinInterval))
+			return exitOK
 		}
 	}
 
 	if cfg.Verbose {
-		slog.SetLogLoggerLevel(slog.LevelDebug)
 		slog.Debug("Using server", "server", cfg.Servers)
-		slog.Debug("Config", "timeout", cfg.TimeoutMS, "retries", cfg.Retries, "syslog", cfg.UseSyslog)
-	} else {
-		slog.SetLogLoggerLevel(slog.LevelInfo)
+		slog.Debug("Config", "timeout", cfg.Timeout, "retries", cfg.Retries, "ntp_version", cfg.NTPVersion, "syslog", cfg.UseSyslog)
 	}
 
-	for attempt := 0; attempt < cfg.Retries; attempt++ {
-		for _, server := range cfg.Servers {
-			if cfg.Verbose {
-				slog.Debug("Attempt at NTP query", "attempt", attempt+1, "server", server)
-			}
-			err = timeSync(server, cfg, time.Duration(cfg.TimeoutMS)*time.Millisecond, syslogWriter)
-			if err == nil {
-				os.Exit(0)
-			}
-			if attempt < cfg.Retries-1 {
-				time.Sleep(200 * time.Millisecond)
-			}
+	ctx := context.Background()
+	if cfg.Deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Deadline)
+		defer cancel()
+	}
+	if cfg.Compare {
+		return runCompare(ctx, cfg, syslogWriter)
+	}
+	if cfg.Check {
+		return runCheck(ctx, cfg, syslogWriter)
+	}
+	if cfg.SetOnlyIfOff {
+		return runSetOnlyIfOff(ctx, cfg, syslogWriter)
+	}
+	if cfg.Daemon {
+		if err = runDaemon(cfg, syslogWriter); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+			return exitCode(err)
 		}
+		return 0
 	}
-	slog.Error("Failed to contact NTP server after retries", "attempts", cfg.Retries)
-	if syslogWriter != nil {
-		syslogWriter.Err(fmt.Sprintf("NTP query failed after %d attempts", cfg.Retries))
+	_, err = syncOnce(ctx, cfg, syslogWriter)
+	if errors.Is(err, context.DeadlineExceeded) {
+		slog.Error("Deadline exceeded, giving up", "deadline", cfg.Deadline)
+		syslogWriter.Err(fmt.Sprintf("Deadline of %v exceeded, giving up", cfg.Deadline))
 	}
-	os.Exit(-1)
+	return exitCode(err)
 }
 
-// timeSync synchronizes the system time with the given NTP server.
-// It performs the following steps:
-// 1. Resolves the IP address of the NTP server.
-// 2. Retrieves the current time from the NTP server.
-// 3. Checks if the retrieved time is valid (year >= 2025).
-// 4. Calculates the time difference between the system time and the NTP time.
-// 5. If the time difference is significant, it adjusts the system time.
-// 6. Logs the results and any errors encountered.
-//
-// Parameters:
-// - server: The NTP server to synchronize with.
-// - test: If true, the function runs in test mode and does not actually set the system time.
-// - timeout: The timeout duration for the NTP query.
-// - syslog: A syslog.Writer to log messages to the system log.
-//
-// Returns an error if any step fails.
-func timeSync(server string, cfg *Config, timeout time.Duration, syslog *syslog.Writer) error {
-	test := cfg.Test
-	var yearLaps int64 = 365 * 24 * 60 * 60 * 1000
-	ips, err := net.LookupIP(server)
-	if err != nil {
-		slog.Error("Could not get IPs:", "error", err)
-		if syslog != nil {
-			syslog.Err(fmt.Sprintf("Could not get IPs: %v\n", err))
+// closeNotifier closes the syslog connection, if it has one, flushing the
+// messages still buffered.
+func closeNotifier(n timesync.Notifier) {
+	if c, ok := n.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			slog.Debug("Failed to close syslog", "error", err)
 		}
-		return err
 	}
-	serverIP := ips[0].String()
-	slog.Debug("Server", "name", server, "ip", serverIP)
-	server = serverIP
-	prepoch := time.Now().UnixMilli()
+}
 
-	// Query NTP with timeout
-	options := ntp.QueryOptions{Timeout: timeout}
-	response, err := ntp.QueryWithOptions(server, options)
-	if err != nil {
-		slog.Error("Failed to query NTP server", "error", err)
-		if syslog != nil {
-			syslog.Err(fmt.Sprintf("Failed to query NTP server: %v", err))
+// syncOnce runs one synchronization through the timesync package and
+// prints its result.
+func syncOnce(ctx context.Context, cfg *Config, syslogWriter timesync.Notifier) (timesync.Result, error) {
+	result, err := timesync.Sync(ctx, cfg.options(syslogWriter))
+	printResult(cfg, result, err)
+	printOffset(cfg, result, err)
+	printDryRun(cfg, result, err)
+	exportResult(cfg, result, err, syslogWriter)
+	return result, err
+}
+
+// exportResult writes the metrics textfile when -textfile is set, and after
+// a success the drift and state files when they are set. Failing to write
+// them is logged but does not fail the synchronization.
+func exportResult(cfg *Config, result timesync.Result, err error, syslogWriter timesync.Notifier) {
+	if cfg.Textfile != "" {
+		if werr := writeTextfile(cfg.Textfile, result, err); werr != nil {
+			slog.Error("Failed to write textfile", "path", cfg.Textfile, "error", werr)
+			syslogWriter.Err(fmt.Sprintf("Failed to write textfile %s: %v", cfg.Textfile, werr))
 		}
-		return err
 	}
-	ntime := time.Now().Add(response.ClockOffset)
-	nyear := ntime.Year()
-	if nyear < 2025 || nyear > 2200 {
-		slog.Error("Year is out of valid range (2025-2200)", "year", nyear)
-		if syslog != nil {
-			syslog.Err(fmt.Sprintf("Year is out of valid range (2025-2200): %v", nyear))
+	if cfg.DriftFile != "" && err == nil {
+		updateDrift(cfg, result, syslogWriter)
+	}
+	if cfg.StateFile != "" && err == nil {
+		if werr := writeState(cfg.StateFile, result); werr != nil {
+			slog.Error("Failed to write state file", "path", cfg.StateFile, "error", werr)
+			syslogWriter.Err(fmt.Sprintf("Failed to write state file %s: %v", cfg.StateFile, werr))
 		}
-		return errors.New("year is out of valid range")
 	}
-	nowpoch := time.Now().UnixMilli()
-	ntimepoch := ntime.UnixMilli()
-	delta := nowpoch - ntimepoch
-	if delta < 0 {
-		delta = -delta
+}
+
+// retryDelay returns the RetryDelay of timesync.Options, where no delay is
+// negative since zero means the default.
+func (cfg *Config) retryDelay() time.Duration {
+	if cfg.RetryDelay == 0 {
+		return -1
 	}
-	if nowpoch-prepoch > 10000 {
-		slog.Error("Time sync took too long", "duration", nowpoch-prepoch)
-		if syslog != nil {
-			syslog.Err(fmt.Sprintf("Time sync took too long (%vms)", nowpoch-prepoch))
+	return cfg.RetryDelay
+}
+
+// applyEnv seeds the timeout and the retries from the NTP_TIMEOUT and
+// NTP_RETRIES environment variables, for containers, unless the flags in
+// set were given on the command line.
+func (cfg *Config) applyEnv(set map[string]bool) error {
+	if v := os.Getenv("NTP_TIMEOUT"); v != "" && !set["t"] {
+		if err := (*msDuration)(&cfg.Timeout).Set(v); err != nil {
+			return fmt.Errorf("NTP_TIMEOUT: %w", err)
 		}
-		return nil
 	}
-	ntime = ntime.Add(time.Millisecond * (time.Duration)((nowpoch-prepoch)/2))
-	ntimepoch = ntime.UnixMilli()
-	roundtrip := nowpoch - prepoch
-	offset := ntimepoch - nowpoch
-
-	if cfg.Verbose {
-		localTime := time.Unix(prepoch/1000, (prepoch%1000)*1000000)
-		remoteTime := time.Unix(ntimepoch/1000, (ntimepoch%1000)*1000000)
-		slog.Debug("Local time", "time", localTime.Format("2006-01-02T15:04:05-0700"), "ms", prepoch%1000)
-		slog.Debug("Remote time", "time", remoteTime.Format("2006-01-02T15:04:05-0700"), "ms", ntimepoch%1000)
-		slog.Debug("Local before(ms)", "ms", prepoch)
-		slog.Debug("Local after(ms)", "ms", nowpoch)
-		slog.Debug("Estimated roundtrip(ms)", "ms", roundtrip)
-		slog.Debug("Estimated offset remote - local(ms)", "ms", offset)
-		if syslog != nil {
-			syslog.Info(fmt.Sprintf("NTP server=%s addr=%s offset_ms=%d rtt_ms=%d", server, serverIP, offset, roundtrip))
+	if v := os.Getenv("NTP_RETRIES"); v != "" && !set["r"] {
+		retries, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("NTP_RETRIES: invalid number %q", v)
 		}
+		cfg.Retries = retries
 	}
+	return nil
+}
 
-	if delta > yearLaps {
-		slog.Info("Time is off by more than a year, not adjusting", "delta", delta)
-	} else {
-		if delta > 500 {
-			err = setSystemDate(ntime, 0, test)
-			if err != nil {
-				slog.Error("Failed to set system date", "error", err)
-				if syslog != nil {
-					syslog.Err(fmt.Sprintf("Failed to set system date: %v", err))
-				}
-				return err
-			} else {
-				slog.Info("System time set to network time", "server", server, "delta", delta)
-				if syslog != nil {
-					syslog.Info("System time set to network time")
-				}
-			}
-		} else {
-			if cfg.Verbose {
-				slog.Info("Delta < 500ms, not setting system time.")
-				if syslog != nil {
-					syslog.Info("Delta < 500ms, not setting system time")
-				}
-			}
+// envServers returns the comma separated servers of the NTP_SERVERS
+// environment variable, empty entries are ignored.
+func envServers() []string {
+	var servers []string
+	for _, server := range strings.Split(os.Getenv("NTP_SERVERS"), ",") {
+		if server = strings.TrimSpace(server); server != "" {
+			servers = append(servers, server)
 		}
 	}
+	return servers
+}
 
-	return nil
+// displayTime formats t in the time zone of -local, after -time-format or
+// else layout.
+func (cfg *Config) displayTime(t time.Time, layout string) string {
+	t = t.In(cfg.Location)
+	if cfg.FormatTime != nil {
+		return cfg.FormatTime(t)
+	}
+	return t.Format(layout)
+}
+
+// lastStep returns the time of the last step recorded in the state file
+// when -step-cooldown needs it.
+func (cfg *Config) lastStep() time.Time {
+	if cfg.StepCooldown <= 0 {
+		return time.Time{}
+	}
+	return lastStepTime(cfg.StateFile)
+}
+
+// options converts the command line configuration to timesync.Options.
+func (cfg *Config) options(syslogWriter timesync.Notifier) timesync.Options {
+	return timesync.Options{
+		Servers:           cfg.Servers,
+		Verbose:           cfg.Verbose,
+		FormatTime:        func(t time.Time) string { return cfg.displayTime(t, timesync.DefaultTimeLayout) },
+		Test:              cfg.Test,
+		Timeout:           cfg.Timeout,
+		ServerTimeouts:    cfg.ServerTimeouts,
+		Retries:           cfg.Retries,
+		RetryMode:         timesync.RetryMode(cfg.RetryMode),
+		MaxQueries:        cfg.MaxQueries,
+		Best:              cfg.Best,
+		Slew:              cfg.Slew,
+		StepThreshold:     cfg.StepThreshold,
+		StepSize:          cfg.StepSize,
+		NoBackward:        cfg.NoBackward,
+		StepCooldown:      cfg.StepCooldown,
+		LastStep:          cfg.lastStep(),
+		LocalAddress:      cfg.Source,
+		IPv4Only:          cfg.IPv4Only,
+		IPv6Only:          cfg.IPv6Only,
+		NoDNS:             cfg.NoDNS,
+		Port:              cfg.Port,
+		Version:           cfg.NTPVersion,
+		Auth:              cfg.Auth,
+		NTS:               cfg.NTS,
+		Samples:           cfg.Samples,
+		KernelTimestamp:   cfg.KernelTimestamp,
+		Warmup:            cfg.Warmup,
+		Burst:             cfg.Burst,
+		FilterK:           cfg.FilterK,
+		MaxStratum:        cfg.MaxStratum,
+		MaxRootDispersion: cfg.MaxRootDispersion,
+		MaxRootDelay:      cfg.MaxRootDelay,
+		MaxJitter:         cfg.MaxJitter,
+		MaxRTT:            cfg.MaxRTT,
+		MaxMeasure:        cfg.MaxMeasure,
+		Asymmetry:         cfg.Asymmetry,
+		AsymmetryFraction: cfg.AsymmetryFraction,
+		PanicThreshold:    cfg.PanicThreshold,
+		Force:             cfg.Force || cfg.ForceYear,
+		ForceYear:         cfg.ForceYear,
+		SyncRTC:           cfg.SyncRTC,
+		QueryOnly:         cfg.Check,
+		Consensus:         cfg.Consensus,
+		Average:           cfg.Average,
+		MinAgree:          cfg.MinAgree,
+		RetryDelay:        cfg.retryDelay(),
+		BackoffMax:        cfg.BackoffMax,
+		Jitter:            cfg.Jitter,
+		RFC868:            cfg.RFC868,
+		HTTPFallback:      cfg.HTTPFallback,
+		Notifier:          syslogWriter,
+	}
 }
@@ -0,0 +1,342 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"js353.com/timesync-mini/pkg/timesync"
+)
+
+// mockSNTP is an in-process SNTP server on a random loopback UDP port.
+// Fields:
+// - Offset: Added to the local time in the answers.
+// - Stratum: Stratum of the answers.
+// - Leap: Leap indicator of the answers.
+type mockSNTP struct {
+	Offset  time.Duration
+	Stratum uint8
+	Leap    uint8
+	conn    *net.UDPConn
+}
+
+// ntpEpochOffset is the number of seconds between 1900 and 1970.
+const ntpEpochOffset = 2208988800
+
+// startMockSNTP starts a mock server answering like m until the test ends.
+func startMockSNTP(t *testing.T, m mockSNTP) *mockSNTP {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.conn = conn
+	t.Cleanup(func() { conn.Close() })
+	go m.serve()
+	return &m
+}
+
+// Addr returns the server as host:port.
+func (m *mockSNTP) Addr() string {
+	return m.conn.LocalAddr().String()
+}
+
+func (m *mockSNTP) serve() {
+	buf := make([]byte, 1024)
+	for {
+		n, addr, err := m.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if n < 48 {
+			continue
+		}
+		now := time.Now().Add(m.Offset)
+		answer := make([]byte, 48)
+		answer[0] = m.Leap<<6 | buf[0]&0x38 | 4 // version of the query, server mode
+		answer[1] = m.Stratum
+		answer[2] = buf[2]
+		answer[3] = 0xec // 2^-20 s precision
+		copy(answer[12:16], "MOCK")
+		putNTPTime(answer[16:], now.Add(-time.Minute))
+		copy(answer[24:32], buf[40:48]) // origin is the transmit time of the query
+		putNTPTime(answer[32:], now)
+		putNTPTime(answer[40:], now)
+		m.conn.WriteToUDP(answer, addr)
+	}
+}
+
+// putNTPTime writes t as a 64-bit NTP timestamp.
+func putNTPTime(b []byte, t time.Time) {
+	binary.BigEndian.PutUint32(b, uint32(t.Unix()+ntpEpochOffset))
+	binary.BigEndian.PutUint32(b[4:], uint32(uint64(t.Nanosecond())<<32/1e9))
+}
+
+// setArgs makes args the command line until the test ends.
+func setArgs(t *testing.T, args ...string) {
+	saved := os.Args
+	t.Cleanup(func() { os.Args = saved })
+	os.Args = append([]string{"timesync"}, args...)
+}
+
+// runWith runs the command with args and returns its exit code.
+func runWith(t *testing.T, args ...string) int {
+	setArgs(t, args...)
+	return run()
+}
+
+func TestMockSNTPEndToEnd(t *testing.T) {
+	t.Setenv("NTP_SERVERS", "")
+	tests := []struct {
+		name   string
+		server mockSNTP
+		args   []string
+		code   int
+	}{
+		{"step in test mode", mockSNTP{Offset: 3 * time.Second, Stratum: 2}, []string{"-n"}, exitOK},
+		{"in sync", mockSNTP{Stratum: 1}, []string{"-n"}, exitOK},
+		{"unsynchronized server", mockSNTP{Offset: 3 * time.Second, Stratum: 16}, []string{"-n"}, exitRejected},
+		{"leap indicator 3", mockSNTP{Stratum: 2, Leap: 3}, []string{"-n"}, exitRejected},
+		{"above the panic threshold", mockSNTP{Offset: time.Hour, Stratum: 2}, []string{"-n"}, exitPanic},
+		{"check above the threshold", mockSNTP{Offset: 3 * time.Second, Stratum: 2}, []string{"-check"}, exitOffset},
+		{"check within the threshold", mockSNTP{Stratum: 2}, []string{"-check"}, exitOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := startMockSNTP(t, tt.server)
+			args := append(tt.args, "-r", "1", "-config", os.DevNull, server.Addr())
+			if code := runWith(t, args...); code != tt.code {
+				t.Errorf("exit code = %d, want %d", code, tt.code)
+			}
+		})
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	dnsErr := &net.DNSError{Err: "no such host", Name: "ntp.test", IsNotFound: true}
+	tests := []struct {
+		name string
+		err  error
+		code int
+	}{
+		{"success", nil, exitOK},
+		{"DNS", fmt.Errorf("giving up after 3 attempts: %w", dnsErr), exitDNS},
+		{"query", fmt.Errorf("giving up: %w", timesync.ErrQueryFailed), exitQuery},
+		{"slow measurement", timesync.ErrSlowMeasurement, exitQuery},
+		{"deadline during the lookup", errors.Join(dnsErr, context.DeadlineExceeded), exitQuery},
+		{"rejected", timesync.ErrStratum, exitRejected},
+		{"rejected after a DNS failure", errors.Join(dnsErr, timesync.ErrBadYear), exitRejected},
+		{"permission", fmt.Errorf("settimeofday: %w", timesync.ErrPermission), exitPermission},
+		{"panic", timesync.ErrPanic, exitPanic},
+		{"daemon start", fmt.Errorf("%w: %w", errDaemonStart, &net.OpError{Op: "listen", Net: "tcp", Err: errors.New("address already in use")}), exitDaemon},
+	}
+	for _, tt := range tests {
+		if code := exitCode(tt.err); code != tt.code {
+			t.Errorf("%s: exitCode = %d, want %d", tt.name, code, tt.code)
+		}
+	}
+}
+
+func TestInvalidOptionsExitCode(t *testing.T) {
+	t.Setenv("NTP_SERVERS", "")
+	tests := []struct {
+		args []string
+		code int
+	}{
+		{[]string{"-no-such-flag"}, exitUsage},
+		{[]string{"-r", "many"}, exitUsage},
+		{[]string{"-t"}, exitUsage},
+		{[]string{"sync", "-check"}, exitUsage},
+		{[]string{"completion", "-no-such-flag", "bash"}, exitUsage},
+		{[]string{"completion", "tcsh"}, exitUsage},
+		{[]string{"version", "extra"}, exitUsage},
+		{[]string{"-r", "1", "-retry-mode", "random"}, exitUsage},
+		{[]string{"-check", "-d"}, exitUsage},
+		{[]string{"-compare", "-d"}, exitUsage},
+		{[]string{"-help"}, exitOK},
+		{[]string{"-h"}, exitOK},
+		{[]string{"sync", "-help"}, exitOK},
+		{[]string{"completion", "-help"}, exitOK},
+	}
+	for _, tt := range tests {
+		if code := runWith(t, tt.args...); code != tt.code {
+			t.Errorf("%q: exit code = %d, want %d", tt.args, code, tt.code)
+		}
+	}
+}
+
+func TestCompletionServers(t *testing.T) {
+	for _, server := range []string{"pool.ntp.org", "ntp_1.lan:123", "192.0.2.1", "[2001:db8::1]:123", "fe80::1%eth0"} {
+		if !completableServer(server) {
+			t.Errorf("%q refused", server)
+		}
+	}
+	for _, server := range []string{"", "a b", "$(reboot)", "`id`", "a;b", "a'b", `a"b`, "a)b", "*"} {
+		if completableServer(server) {
+			t.Errorf("%q accepted", server)
+		}
+	}
+
+	t.Setenv("NTP_SERVERS", "")
+	serversFile := filepath.Join(t.TempDir(), "servers")
+	if err := os.WriteFile(serversFile, []byte("pool.ntp.org\n$(touch pwned)\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, shell := range []string{"bash", "zsh", "fish"} {
+		if code := runWith(t, "completion", "-servers-file", serversFile, shell); code != exitUsage {
+			t.Errorf("%s: exit code = %d, want %d", shell, code, exitUsage)
+		}
+	}
+	t.Setenv("NTP_SERVERS", "pool.ntp.org,a;reboot")
+	if code := runWith(t, "completion", "bash"); code != exitUsage {
+		t.Errorf("NTP_SERVERS: exit code = %d, want %d", code, exitUsage)
+	}
+}
+
+func TestServerPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "timesync.conf")
+	if err := os.WriteFile(config, []byte(`servers = ["config.example"]`+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	serversFile := filepath.Join(dir, "servers")
+	if err := os.WriteFile(serversFile, []byte("file.example\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		env     string
+		args    []string
+		servers []string
+	}{
+		{"command line first", "env.example", []string{"cli.example"}, []string{"cli.example"}},
+		{"-server flag", "env.example", []string{"-server", "flag.example"}, []string{"flag.example"}},
+		{"environment before the configuration file", "env.a,,env.b", nil, []string{"env.a", "env.b"}},
+		{"configuration file", "", nil, []string{"config.example"}},
+		{"servers file before the environment", "env.example", []string{"-servers-file", serversFile}, []string{"file.example"}},
+		{"servers file before the configuration file", "", []string{"-servers-file", serversFile}, []string{"file.example"}},
+		{"servers file added to the command line", "env.example", []string{"-servers-file", serversFile, "cli.example"}, []string{"cli.example", "file.example"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("NTP_SERVERS", tt.env)
+			setArgs(t, append([]string{"-n", "-config", config}, tt.args...)...)
+			cfg, err := parseConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(cfg.Servers, tt.servers) {
+				t.Errorf("servers = %q, want %q", cfg.Servers, tt.servers)
+			}
+		})
+	}
+}
+
+func TestAsymmetryFlag(t *testing.T) {
+	tests := []struct {
+		value    string
+		delay    time.Duration
+		fraction float64
+	}{
+		{"0.6", 0, 0.6},
+		{"4", 4 * time.Millisecond, 0},
+		{"-2.5", -2500 * time.Microsecond, 0},
+		{"1", time.Millisecond, 0},
+		{"3ms", 3 * time.Millisecond, 0},
+		{"-1ms", -time.Millisecond, 0},
+	}
+	for _, tt := range tests {
+		delay, fraction := time.Hour, 0.9
+		a := &asymmetry{delay: &delay, fraction: &fraction}
+		if err := a.Set(tt.value); err != nil {
+			t.Errorf("%q: %v", tt.value, err)
+			continue
+		}
+		if delay != tt.delay || fraction != tt.fraction {
+			t.Errorf("%q: delay %v, fraction %v, want %v and %v", tt.value, delay, fraction, tt.delay, tt.fraction)
+		}
+	}
+	var delay time.Duration
+	var fraction float64
+	if err := (&asymmetry{delay: &delay, fraction: &fraction}).Set("slow"); err == nil {
+		t.Error("asymmetry accepted \"slow\"")
+	}
+}
+
+func TestCheckNoDNSScoped(t *testing.T) {
+	for _, server := range []string{"fe80::1%eth0", "[fe80::1%eth0]:123", "192.0.2.1:123", "2001:db8::1"} {
+		if err := (&Config{Servers: []string{server}}).checkNoDNS(); err != nil {
+			t.Errorf("%s: %v", server, err)
+		}
+	}
+	if err := (&Config{Servers: []string{"ntp.example"}}).checkNoDNS(); err == nil {
+		t.Error("-no-dns accepted a host name")
+	}
+}
+
+func TestSetOnlyIfOffRefusedStep(t *testing.T) {
+	t.Setenv("NTP_SERVERS", "")
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	tests := []struct {
+		name   string
+		offset time.Duration
+		args   []string
+		code   int
+		log    string
+	}{
+		{"step", 3 * time.Second, nil, exitOK, "stepping the clock"},
+		{"backward refused", -3 * time.Second, []string{"-no-backward"}, exitOffset, "step refused (cooldown/no-backward)"},
+		{"within the threshold", 100 * time.Millisecond, nil, exitOK, "Offset within the step threshold"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs.Reset()
+			server := startMockSNTP(t, mockSNTP{Offset: tt.offset, Stratum: 2})
+			args := append([]string{"-set-only-if-off", "-n", "-r", "1", "-config", os.DevNull}, tt.args...)
+			if code := runWith(t, append(args, server.Addr())...); code != tt.code {
+				t.Errorf("exit code = %d, want %d", code, tt.code)
+			}
+			if !strings.Contains(logs.String(), tt.log) {
+				t.Errorf("log %q does not contain %q", logs.String(), tt.log)
+			}
+			if tt.name != "within the threshold" && strings.Contains(logs.String(), "within the step threshold") {
+				t.Errorf("log %q reports the offset within the threshold", logs.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// ntpConfPaths are the configuration files of the usual NTP daemons, the
+// first one found is used by -use-ntpconf.
+var ntpConfPaths = []string{
+	"/etc/ntp.conf",
+	"/etc/ntpsec/ntp.conf",
+	"/etc/chrony/chrony.conf",
+	"/etc/chrony.conf",
+}
+
+// readNTPConf returns the servers of the first NTP daemon configuration file
+// found in ntpConfPaths, and the path it was read from.
+func readNTPConf() ([]string, string, error) {
+	for _, path := range ntpConfPaths {
+		servers, err := parseNTPConf(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		return servers, path, err
+	}
+	return nil, "", fmt.Errorf("no NTP configuration found in %s", strings.Join(ntpConfPaths, ", "))
+}
+
+// parseNTPConf returns the hosts of the server and pool directives of an
+// ntpd or chronyd configuration file. Options following the host, such as
+// iburst, are ignored, as are the 127.127.x.x reference clocks of ntpd.
+func parseNTPConf(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var servers []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) < 2 || (fields[0] != "server" && fields[0] != "pool") {
+			continue
+		}
+		if strings.HasPrefix(fields[1], "127.127.") {
+			continue
+		}
+		servers = append(servers, fields[1])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return servers, nil
+}
@@ -0,0 +1,89 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"js353.com/timesync-mini/pkg/timesync"
+)
+
+// syncResult summarizes one synchronization for the -json output.
+type syncResult struct {
+	Server      string `json:"server"`
+	IP          string `json:"ip,omitempty"`
+	OffsetMS    int64  `json:"offset_ms"`
+	RTTMS       int64  `json:"rtt_ms"`
+	Stratum     uint8  `json:"stratum"`
+	PrecisionNS int64  `json:"precision_ns"`
+	Adjusted    bool   `json:"adjusted"`
+	Action      string `json:"action,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// printResult writes result as a single JSON line on stdout when -json is
+// set. Logs always go to stderr so stdout can be piped into jq.
+func printResult(cfg *Config, result timesync.Result, err error) {
+	if !cfg.JSON {
+		return
+	}
+	out := syncResult{
+		Server:      result.Server,
+		IP:          result.IP,
+		OffsetMS:    result.Offset.Milliseconds(),
+		RTTMS:       result.RTT.Milliseconds(),
+		Stratum:     result.Stratum,
+		PrecisionNS: result.Precision.Nanoseconds(),
+		Adjusted:    result.Changed,
+		Action:      string(result.Action),
+	}
+	if err != nil {
+		out.Error = err.Error()
+	}
+	json.NewEncoder(os.Stdout).Encode(out)
+}
+
+// printOffset writes the offset in seconds alone on stdout when
+// -print-offset is set, for OFFSET=$(timesync -print-offset -n).
+func printOffset(cfg *Config, result timesync.Result, err error) {
+	if !cfg.PrintOffset || err != nil {
+		return
+	}
+	fmt.Printf("%.6f\n", result.Offset.Seconds())
+}
+
+// printDryRun writes a one line summary of what a real run would do when
+// running in test mode, unless -quiet, -json or -print-offset is set.
+func printDryRun(cfg *Config, result timesync.Result, err error) {
+	if !cfg.Test || cfg.Quiet || cfg.JSON || cfg.PrintOffset || err != nil || result.Action == "" {
+		return
+	}
+	now := time.Now()
+	fmt.Printf("Dry run: system %s, proposed %s, delta %+dms, would %s\n",
+		cfg.displayTime(now, time.RFC3339Nano), cfg.displayTime(now.Add(result.Offset), time.RFC3339Nano),
+		result.Offset.Milliseconds(), result.Action)
+}
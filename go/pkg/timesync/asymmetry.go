@@ -0,0 +1,42 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package timesync
+
+import "time"
+
+// correctAsymmetry returns the offset measured with the roundtrip rtt,
+// corrected for a path whose outbound and return delays differ.
+//
+// SNTP assumes both directions take half the roundtrip. When the outbound
+// delay exceeds the return one by a, the measured offset is too large by
+// a/2. The difference a is Options.Asymmetry, or comes from the outbound
+// share f of the roundtrip given by Options.AsymmetryFraction,
+// a = (2f-1) * rtt.
+func (opts *Options) correctAsymmetry(offset, rtt time.Duration) time.Duration {
+	a := opts.Asymmetry
+	if opts.AsymmetryFraction != 0 {
+		a = time.Duration((2*opts.AsymmetryFraction - 1) * float64(rtt))
+	}
+	return offset - a/2
+}
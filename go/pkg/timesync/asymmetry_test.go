@@ -0,0 +1,107 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package timesync
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/beevik/ntp"
+)
+
+func TestCorrectAsymmetry(t *testing.T) {
+	tests := []struct {
+		name      string
+		asymmetry time.Duration
+		fraction  float64
+		offset    time.Duration
+		rtt       time.Duration
+		want      time.Duration
+	}{
+		{"none", 0, 0, 10 * time.Millisecond, 40 * time.Millisecond, 10 * time.Millisecond},
+		{"slower outbound", 20 * time.Millisecond, 0, 10 * time.Millisecond, 40 * time.Millisecond, 0},
+		{"slower return", -20 * time.Millisecond, 0, 10 * time.Millisecond, 40 * time.Millisecond, 20 * time.Millisecond},
+		{"negative offset", 6 * time.Millisecond, 0, -10 * time.Millisecond, 40 * time.Millisecond, -13 * time.Millisecond},
+		// 75% of 40ms outbound is 30ms against 10ms back, a = 20ms.
+		{"fraction 0.75", 0, 0.75, 10 * time.Millisecond, 40 * time.Millisecond, 0},
+		{"fraction 0.25", 0, 0.25, 10 * time.Millisecond, 40 * time.Millisecond, 20 * time.Millisecond},
+		{"fraction 0.5 is symmetric", 0, 0.5, 10 * time.Millisecond, 40 * time.Millisecond, 10 * time.Millisecond},
+		{"fraction overrides the delay", 50 * time.Millisecond, 0.75, 10 * time.Millisecond, 40 * time.Millisecond, 0},
+	}
+	for _, tt := range tests {
+		opts := &Options{Asymmetry: tt.asymmetry, AsymmetryFraction: tt.fraction}
+		if got := opts.correctAsymmetry(tt.offset, tt.rtt); got != tt.want {
+			t.Errorf("%s: correctAsymmetry(%v, %v) = %v, want %v", tt.name, tt.offset, tt.rtt, got, tt.want)
+		}
+	}
+}
+
+func TestSyncAsymmetry(t *testing.T) {
+	// 600ms measured, 200ms of it come from the slower outbound path: the
+	// corrected 500ms offset stays below the step threshold.
+	opts := offlineOptions(func() (*ntp.Response, error) { return fakeAnswer(600 * time.Millisecond), nil }, nil)
+	opts.Asymmetry = 200 * time.Millisecond
+	opts.StepThreshold = 500 * time.Millisecond
+	result, err := Sync(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Offset != 500*time.Millisecond || result.Action != ActionSkip {
+		t.Errorf("Offset = %v, Action = %q, want 500ms and %q", result.Offset, result.Action, ActionSkip)
+	}
+}
+
+// TestServerTimeAgainstRoundtripHeuristic compares, on a synthetic
+// exchange with known timestamps, the RFC 5905 offset applied as is with the
+// former correction adding a quarter of the roundtrip on top of it.
+func TestServerTimeAgainstRoundtripHeuristic(t *testing.T) {
+	const truth = 2*time.Second + 250*time.Millisecond // server ahead of the client
+	const oneWay = 40 * time.Millisecond
+	const processing = 4 * time.Millisecond
+	t1 := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC) // client transmit, local clock
+	t2 := t1.Add(oneWay + truth)                       // server receive, server clock
+	t3 := t2.Add(processing)                           // server transmit, server clock
+	t4 := t1.Add(2*oneWay + processing)                // client receive, local clock
+
+	offset := (t2.Sub(t1) + t3.Sub(t4)) / 2
+	rtt := t4.Sub(t1) - t3.Sub(t2)
+	if offset != truth || rtt != 2*oneWay {
+		t.Fatalf("synthetic exchange gives offset %v and rtt %v", offset, rtt)
+	}
+
+	nowpoch := t4.UnixMilli()
+	want := t4.Add(truth)
+	if got := serverTime(nowpoch, offset); !got.Equal(want) {
+		t.Errorf("serverTime = %v, want %v", got, want)
+	}
+	prepoch := t1.UnixMilli()
+	heuristic := time.UnixMilli(nowpoch).Add(offset).Add(time.Duration(nowpoch-prepoch) * time.Millisecond / 4)
+	if heuristic.Equal(want) {
+		t.Errorf("the roundtrip/4 heuristic gives the true time %v, the exchange does not tell them apart", want)
+	}
+	if d := heuristic.Sub(want); d != (2*oneWay+processing)/4 {
+		t.Errorf("heuristic is %v off, want a quarter of the roundtrip", d)
+	}
+}
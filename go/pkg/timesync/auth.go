@@ -0,0 +1,91 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package timesync
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/beevik/ntp"
+)
+
+// authTypes maps the key types found in ntp.keys files to beevik/ntp
+// authentication types.
+var authTypes = map[string]ntp.AuthType{
+	"M":          ntp.AuthMD5,
+	"MD5":        ntp.AuthMD5,
+	"SHA1":       ntp.AuthSHA1,
+	"SHA256":     ntp.AuthSHA256,
+	"SHA512":     ntp.AuthSHA512,
+	"AES128CMAC": ntp.AuthAES128,
+	"AES256CMAC": ntp.AuthAES256,
+}
+
+// LoadAuthKey looks up keyID in an ntp.keys style file. Each line holds a
+// key id, a type and the key itself, '#' starts a comment. As with ntpd,
+// keys longer than 20 characters are hex encoded, shorter ones are ASCII.
+// The result is meant for Options.Auth.
+func LoadAuthKey(path string, keyID int) (ntp.AuthOptions, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return ntp.AuthOptions{}, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 3 {
+			return ntp.AuthOptions{}, fmt.Errorf("%s:%d: expected keyid, type and key", path, lineno)
+		}
+		id, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return ntp.AuthOptions{}, fmt.Errorf("%s:%d: invalid key id %q", path, lineno, fields[0])
+		}
+		if id != keyID {
+			continue
+		}
+		authType, ok := authTypes[strings.ToUpper(fields[1])]
+		if !ok {
+			return ntp.AuthOptions{}, fmt.Errorf("%s:%d: unsupported key type %q", path, lineno, fields[1])
+		}
+		return ntp.AuthOptions{Type: authType, Key: fields[2], KeyID: uint16(keyID)}, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return ntp.AuthOptions{}, err
+	}
+	return ntp.AuthOptions{}, fmt.Errorf("%s: key %d not found", path, keyID)
+}
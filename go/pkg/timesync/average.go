@@ -0,0 +1,60 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package timesync
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// syncAverage queries every configured server concurrently, drops the
+// offsets that are outliers among the answers and applies the mean of the
+// others, so that the bias of any single server is smoothed out. It is a
+// simpler alternative to syncConsensus, no server needs to agree with any
+// other.
+func syncAverage(ctx context.Context, attempt int, opts *Options, denied map[string]bool) (Result, error) {
+	samples, err := collectSamples(ctx, attempt, opts, denied)
+	if err != nil {
+		return Result{}, err
+	}
+	for _, sample := range samples {
+		slog.Info("Server offset", "server", sample.server, "ip", sample.ip,
+			"offset_ms", sample.response.ClockOffset.Milliseconds(), "rtt_ms", sample.response.RTT.Milliseconds())
+	}
+	kept := madFilter(samples, opts.FilterK)
+	var sum time.Duration
+	best := kept[0]
+	for _, sample := range kept {
+		sum += sample.response.ClockOffset
+		if sample.response.RTT < best.response.RTT {
+			best = sample
+		}
+	}
+	offset := sum / time.Duration(len(kept))
+	slog.Info("Averaged offset", "servers", len(kept), "answered", len(samples), "offset_ms", offset.Milliseconds())
+	// Apply the mean through the kept sample with the lowest roundtrip so
+	// the usual sanity checks still run.
+	return applySample(withOffset(best, offset), opts)
+}
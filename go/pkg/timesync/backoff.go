@@ -0,0 +1,54 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package timesync
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// Retry delays: the first retry waits Options.RetryDelay, DefaultRetryDelay
+// unless set, every further one doubles it up to Options.BackoffMax.
+const (
+	DefaultRetryDelay = 200 * time.Millisecond
+	DefaultBackoffMax = 5 * time.Second
+)
+
+// backoff returns the delay before the retry following the given number of
+// failed queries. With Jitter the delay is drawn between half and all of it
+// so that machines started together drift apart.
+func (opts *Options) backoff(failures int) time.Duration {
+	if opts.RetryDelay < 0 {
+		return 0
+	}
+	delay := opts.RetryDelay
+	for i := 0; i < failures && delay < opts.BackoffMax; i++ {
+		delay *= 2
+	}
+	delay = min(delay, opts.BackoffMax)
+	if opts.Jitter && delay > 1 {
+		delay = delay/2 + rand.N(delay/2)
+	}
+	return delay
+}
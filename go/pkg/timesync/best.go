@@ -0,0 +1,137 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package timesync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// syncBest queries every configured server concurrently and applies the
+// sample with the lowest roundtrip, using the root dispersion to break ties.
+// Servers whose offset is an outlier among the answers are left out.
+// Kiss-o'-death answers are returned in the error for handleKiss.
+func syncBest(ctx context.Context, attempt int, opts *Options, denied map[string]bool) (Result, error) {
+	samples, err := collectSamples(ctx, attempt, opts, denied)
+	if err != nil {
+		return Result{}, err
+	}
+	var best *ntpSample
+	for _, sample := range madFilter(samples, opts.FilterK) {
+		if best == nil || sample.response.RTT < best.response.RTT ||
+			(sample.response.RTT == best.response.RTT &&
+				sample.response.RootDispersion < best.response.RootDispersion) {
+			best = sample
+		}
+	}
+	slog.Debug("Selected server", "server", best.server, "ip", best.ip, "rtt_ms", best.response.RTT.Milliseconds())
+	return applySample(best, opts)
+}
+
+// collectSamples queries every configured server concurrently and returns
+// the answers received within collectBudget. Servers listed in denied are
+// skipped, failed servers and kiss-o'-death answers are left out. It only
+// fails when no usable answer came back, the error then carries the kisses.
+func collectSamples(ctx context.Context, attempt int, opts *Options, denied map[string]bool) ([]*ntpSample, error) {
+	notifier := opts.Notifier
+	// Wait for the slowest server, the queries still running are cancelled
+	// on return.
+	timeout := opts.Timeout
+	for _, t := range opts.ServerTimeouts {
+		timeout = max(timeout, t)
+	}
+	qctx, cancel := context.WithTimeout(ctx, collectBudget(timeout, opts))
+	defer cancel()
+	// Buffered so late answers do not block their goroutine once we stop
+	// listening.
+	results := make(chan *ntpSample, len(opts.Servers))
+	queried := 0
+	for _, server := range opts.Servers {
+		if denied[server] {
+			continue
+		}
+		queried++
+		go func(server string) {
+			// Errors are already logged, a failed server just sends nil.
+			sample, _ := queryServer(qctx, server, attempt, opts)
+			results <- sample
+		}(server)
+	}
+
+	var samples []*ntpSample
+	var kisses []error
+collect:
+	for range queried {
+		select {
+		case sample := <-results:
+			if sample == nil {
+				continue
+			}
+			if sample.response.IsKissOfDeath() {
+				slog.Warn("Kiss-o'-death received", "server", sample.server, "code", sample.response.KissCode)
+				notifier.Warning(fmt.Sprintf("Kiss-o'-death %s received from %s", sample.response.KissCode, sample.server))
+				kisses = append(kisses, &kissError{server: sample.server, code: sample.response.KissCode})
+				continue
+			}
+			slog.Debug("Candidate", "server", sample.server, "ip", sample.ip,
+				"rtt_ms", sample.response.RTT.Milliseconds(),
+				"offset_ms", sample.response.ClockOffset.Milliseconds())
+			samples = append(samples, sample)
+		case <-qctx.Done():
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			slog.Debug("Timeout waiting for remaining servers")
+			break collect
+		}
+	}
+	if len(samples) == 0 {
+		slog.Error("No NTP server answered", "servers", queried)
+		notifier.Err(fmt.Sprintf("No NTP server answered out of %d", queried))
+		return nil, errors.Join(append(kisses, fmt.Errorf("%w: no NTP server answered", ErrQueryFailed))...)
+	}
+	return samples, nil
+}
+
+// collectBudget returns how long collectSamples waits for the servers: the
+// NTS key exchange, the warmup query and every sample may each take timeout,
+// plus the spacing between the samples.
+func collectBudget(timeout time.Duration, opts *Options) time.Duration {
+	queries := max(opts.Samples, 1)
+	spacing := sampleSpacing
+	if opts.Burst {
+		spacing = burstSpacing
+	}
+	budget := time.Duration(queries)*timeout + time.Duration(queries-1)*spacing
+	if opts.Warmup {
+		budget += timeout
+	}
+	if opts.NTS {
+		budget += timeout
+	}
+	return budget
+}
@@ -0,0 +1,54 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package timesync
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// ErrQueryBudget is returned instead of sending a query once
+// Options.MaxQueries packets were sent, it wraps ErrQueryFailed.
+var ErrQueryBudget = fmt.Errorf("%w: query budget exhausted", ErrQueryFailed)
+
+// queryBudget counts the NTP packets sent by a Sync, shared by the
+// concurrent queries of Best and Consensus.
+// Fields:
+// - max: Number of packets allowed.
+// - sent: Number of packets sent or about to be.
+type queryBudget struct {
+	max  int64
+	sent atomic.Int64
+}
+
+// take reserves one packet and reports whether it may be sent. A nil budget
+// never runs out.
+func (b *queryBudget) take() bool {
+	return b == nil || b.sent.Add(1) <= b.max
+}
+
+// exhausted reports whether no packet can be sent anymore.
+func (b *queryBudget) exhausted() bool {
+	return b != nil && b.sent.Load() >= b.max
+}
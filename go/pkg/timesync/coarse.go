@@ -0,0 +1,90 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package timesync
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// coarsePrecision is the resolution of the fallback sources, the HTTP Date
+// header and RFC 868 both carry whole seconds.
+const coarsePrecision = time.Second
+
+// coarseOffset returns the offset given by a whole second remote time read
+// during an exchange sent at sent and lasting rtt. The remote clock was
+// truncated somewhere during the exchange, the middle of both is assumed.
+func coarseOffset(remote time.Time, sent time.Time, rtt time.Duration) time.Duration {
+	return remote.Add(coarsePrecision / 2).Sub(sent.Add(rtt / 2))
+}
+
+// applyCoarse runs the sanity checks on a time obtained from a low precision
+// fallback source and steps the clock when it is off by more than both the
+// step threshold and the precision of the source. result must carry the
+// source in Server and the measured Offset and RTT.
+func applyCoarse(result Result, sent time.Time, opts *Options) (Result, error) {
+	notifier := opts.Notifier
+	offset := result.Offset
+	result.Precision = coarsePrecision
+	slog.Warn("Using low precision fallback time", "source", result.Server, "offset_ms", offset.Milliseconds(), "precision", coarsePrecision)
+	notifier.Warning(fmt.Sprintf("Using low precision fallback time from %s, offset_ms=%d", result.Server, offset.Milliseconds()))
+
+	ntime := sent.Add(time.Since(sent) + offset)
+	if year := ntime.Year(); year < 2025 || year > 2200 {
+		slog.Error("Year is out of valid range (2025-2200)", "year", year)
+		notifier.Err(fmt.Sprintf("Year is out of valid range (2025-2200): %v", year))
+		return result, fmt.Errorf("%w: %d", ErrBadYear, year)
+	}
+	if opts.QueryOnly {
+		return result, nil
+	}
+	if err := opts.beyondYear(result.Server, offset); err != nil {
+		return result, err
+	}
+	if !opts.Force && offset.Abs() > opts.PanicThreshold {
+		slog.Error("Offset above the panic threshold, not adjusting", "source", result.Server, "offset", offset.Round(time.Second), "threshold", opts.PanicThreshold)
+		notifier.Err(fmt.Sprintf("Offset %v from %s is above the panic threshold %v, not adjusting", offset.Round(time.Second), result.Server, opts.PanicThreshold))
+		return result, fmt.Errorf("%w: offset %v from %s is above %v", ErrPanic, offset.Round(time.Second), result.Server, opts.PanicThreshold)
+	}
+	if offset.Abs() <= max(opts.StepThreshold, coarsePrecision) {
+		result.Action = ActionSkip
+		slog.Info("Fallback time within its precision, not setting system time", "offset_ms", offset.Milliseconds())
+		return result, nil
+	}
+	if opts.stepRefused(result.Server) || opts.backwardRefused(result.Server, offset) {
+		result.Action = ActionSkip
+		return result, nil
+	}
+	if ntime, err := opts.stepClock(sent, offset); err != nil {
+		reportSetError(err, ntime, notifier)
+		return result, err
+	}
+	result.Changed = !opts.Test
+	result.Action = ActionStep
+	syncHardwareClock(opts)
+	slog.Info("System time set from fallback source", "source", result.Server, "delta", offset.Milliseconds())
+	notifier.Info(fmt.Sprintf("System time set from fallback source %s", result.Server))
+	return result, nil
+}
@@ -0,0 +1,57 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package timesync
+
+import (
+	"context"
+	"slices"
+)
+
+// Compare queries every server of opts concurrently, without ever touching
+// the clock, and returns one Result per server that answered in the order of
+// opts.Servers. Servers that failed are logged and left out, it only fails
+// when none answered.
+func Compare(ctx context.Context, opts Options) ([]Result, error) {
+	opts = opts.withDefaults()
+	opts.QueryOnly = true
+	samples, err := collectSamples(ctx, 0, &opts, map[string]bool{})
+	if err != nil {
+		return nil, err
+	}
+	// Answers come in arrival order.
+	order := map[string]int{}
+	for i, server := range opts.Servers {
+		if _, ok := order[server]; !ok {
+			order[server] = i
+		}
+	}
+	slices.SortStableFunc(samples, func(a, b *ntpSample) int {
+		return order[a.server] - order[b.server]
+	})
+	results := make([]Result, 0, len(samples))
+	for _, sample := range samples {
+		results = append(results, sample.result())
+	}
+	return results, nil
+}
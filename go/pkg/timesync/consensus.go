@@ -0,0 +1,135 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package timesync
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+)
+
+// DefaultMinAgree is the number of servers that must agree in consensus
+// mode when Options.MinAgree is not set.
+const DefaultMinAgree = 2
+
+// syncConsensus queries every configured server concurrently, keeps the
+// servers whose offset intervals intersect and applies the
+// midpoint of the intersection. It fails when fewer than opts.MinAgree
+// servers agree.
+func syncConsensus(ctx context.Context, attempt int, opts *Options, denied map[string]bool) (Result, error) {
+	notifier := opts.Notifier
+	samples, err := collectSamples(ctx, attempt, opts, denied)
+	if err != nil {
+		return Result{}, err
+	}
+	low, high, agreeing := marzullo(samples)
+	for _, sample := range samples {
+		if !contains(agreeing, sample) {
+			slog.Warn("Falseticker rejected", "server", sample.server, "ip", sample.ip,
+				"offset_ms", sample.response.ClockOffset.Milliseconds())
+		}
+	}
+	if len(agreeing) < opts.MinAgree {
+		slog.Error("Not enough servers agree", "agree", len(agreeing), "answered", len(samples), "min", opts.MinAgree)
+		notifier.Err(fmt.Sprintf("Only %d of %d servers agree, %d required", len(agreeing), len(samples), opts.MinAgree))
+		return Result{}, fmt.Errorf("%w: only %d of %d servers agree, %d required", ErrRejected, len(agreeing), len(samples), opts.MinAgree)
+	}
+
+	// Apply the midpoint through the agreeing sample with the lowest
+	// roundtrip so the usual sanity checks still run.
+	best := agreeing[0]
+	for _, sample := range agreeing[1:] {
+		if sample.response.RTT < best.response.RTT {
+			best = sample
+		}
+	}
+	offset := low + (high-low)/2
+	slog.Debug("Consensus", "agree", len(agreeing), "answered", len(samples),
+		"low_ms", low.Milliseconds(), "high_ms", high.Milliseconds(), "offset_ms", offset.Milliseconds())
+	return applySample(withOffset(best, offset), opts)
+}
+
+// marzullo returns the interval where the largest number of sample offset
+// intervals overlap, and the samples whose interval intersects it.
+func marzullo(samples []*ntpSample) (time.Duration, time.Duration, []*ntpSample) {
+	type edge struct {
+		at    time.Duration
+		start bool
+	}
+	edges := make([]edge, 0, 2*len(samples))
+	for _, sample := range samples {
+		offset, half := sample.response.ClockOffset, distance(sample)
+		edges = append(edges, edge{offset - half, true}, edge{offset + half, false})
+	}
+	// Starts sort before ends at the same instant so touching intervals
+	// count as overlapping.
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].at != edges[j].at {
+			return edges[i].at < edges[j].at
+		}
+		return edges[i].start && !edges[j].start
+	})
+
+	var low, high time.Duration
+	best, count := 0, 0
+	for i, e := range edges {
+		if !e.start {
+			count--
+			continue
+		}
+		count++
+		if count > best {
+			best = count
+			low, high = e.at, edges[i+1].at
+		}
+	}
+
+	var agreeing []*ntpSample
+	for _, sample := range samples {
+		offset, half := sample.response.ClockOffset, distance(sample)
+		if offset-half <= high && offset+half >= low {
+			agreeing = append(agreeing, sample)
+		}
+	}
+	return low, high, agreeing
+}
+
+// distance returns the half width of the interval the true offset of a
+// sample lies in: half the roundtrip plus the root distance of the server.
+func distance(sample *ntpSample) time.Duration {
+	r := sample.response
+	return r.RTT/2 + r.RootDelay/2 + r.RootDispersion
+}
+
+// contains reports whether sample is in samples.
+func contains(samples []*ntpSample, sample *ntpSample) bool {
+	for _, s := range samples {
+		if s == sample {
+			return true
+		}
+	}
+	return false
+}
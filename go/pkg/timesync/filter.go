@@ -0,0 +1,83 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package timesync
+
+import (
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// DefaultFilterK is the number of median absolute deviations an offset may
+// be away from the median when Options.FilterK is not set.
+const DefaultFilterK = 3.0
+
+// minMAD is the smallest median absolute deviation used by madFilter, a
+// tighter spread is measurement noise and would reject good samples.
+const minMAD = time.Millisecond
+
+// madFilter drops the samples whose offset is more than k median absolute
+// deviations away from the median offset, it never returns fewer than one.
+// Fewer than three samples are returned as is, there is no meaningful
+// median to compare them with.
+func madFilter(samples []*ntpSample, k float64) []*ntpSample {
+	if len(samples) < 3 {
+		return samples
+	}
+	offsets := make([]time.Duration, len(samples))
+	for i, sample := range samples {
+		offsets[i] = sample.response.ClockOffset
+	}
+	med := median(offsets)
+	deviations := make([]time.Duration, len(samples))
+	for i, offset := range offsets {
+		deviations[i] = (offset - med).Abs()
+	}
+	limit := time.Duration(k * float64(max(median(deviations), minMAD)))
+	// The median sample, or both middle ones, are always kept whatever k,
+	// a k below 1 would otherwise drop every sample.
+	limit = max(limit, slices.Min(deviations))
+
+	var kept []*ntpSample
+	for i, sample := range samples {
+		if deviations[i] > limit {
+			slog.Debug("Outlier dropped", "server", sample.server, "ip", sample.ip,
+				"offset_ms", offsets[i].Milliseconds(), "median_ms", med.Milliseconds(), "limit_ms", limit.Milliseconds())
+			continue
+		}
+		kept = append(kept, sample)
+	}
+	return kept
+}
+
+// median returns the median of values.
+func median(values []time.Duration) time.Duration {
+	values = slices.Clone(values)
+	slices.Sort(values)
+	n := len(values)
+	if n%2 == 1 {
+		return values[n/2]
+	}
+	return (values[n/2-1] + values[n/2]) / 2
+}
@@ -0,0 +1,77 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package timesync
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/beevik/ntp"
+)
+
+// offsetSamples returns one sample per offset, in milliseconds.
+func offsetSamples(offsets ...int) []*ntpSample {
+	samples := make([]*ntpSample, len(offsets))
+	for i, ms := range offsets {
+		samples[i] = &ntpSample{
+			server:   "s",
+			response: &ntp.Response{ClockOffset: time.Duration(ms) * time.Millisecond},
+		}
+	}
+	return samples
+}
+
+func keptOffsets(samples []*ntpSample) []int {
+	var offsets []int
+	for _, sample := range samples {
+		offsets = append(offsets, int(sample.response.ClockOffset.Milliseconds()))
+	}
+	return offsets
+}
+
+func TestMADFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		offsets []int
+		k       float64
+		want    []int
+	}{
+		{"too few", []int{0, 1000}, 3, []int{0, 1000}},
+		{"outlier dropped", []int{10, 11, 12, 13, 500}, 3, []int{10, 11, 12, 13}},
+		{"negative outlier dropped", []int{-900, 10, 11, 12, 13}, 3, []int{10, 11, 12, 13}},
+		{"noise under minMAD kept", []int{10, 10, 10, 12}, 3, []int{10, 10, 10, 12}},
+		{"small k keeps both middle samples", []int{0, 10, 20, 30}, 0.4, []int{10, 20}},
+		{"small k keeps the median sample", []int{0, 10, 20, 30, 40}, 0.1, []int{20}},
+		{"zero k keeps the median sample", []int{5, 7, 9}, 0, []int{7}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := keptOffsets(madFilter(offsetSamples(tt.offsets...), tt.k))
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("madFilter(%v, %v) = %v, want %v", tt.offsets, tt.k, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package timesync
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// syncHTTPDate is the last resort when no NTP server answered: it sends a
+// HEAD request to opts.HTTPFallback and reads the time from the Date header
+// of the response. The header only has a one second resolution, see
+// applyCoarse.
+func syncHTTPDate(ctx context.Context, opts *Options) (Result, error) {
+	notifier := opts.Notifier
+	url := opts.HTTPFallback
+	result := Result{Server: url}
+
+	hctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(hctx, http.MethodHead, url, nil)
+	if err != nil {
+		return result, err
+	}
+	sent := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		slog.Error("HTTP fallback failed", "url", url, "error", err)
+		notifier.Err(fmt.Sprintf("HTTP fallback to %s failed: %v", url, err))
+		return result, err
+	}
+	resp.Body.Close()
+	rtt := time.Since(sent)
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		slog.Error("HTTP fallback has no usable Date header", "url", url, "error", err)
+		return result, fmt.Errorf("no usable Date header from %s: %w", url, err)
+	}
+
+	result.Offset = coarseOffset(date, sent, rtt)
+	result.RTT = rtt
+	return applyCoarse(result, sent, opts)
+}
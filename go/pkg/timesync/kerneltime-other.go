@@ -21,25 +21,17 @@
 // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
 // SOFTWARE.
 
-//go:build linux && 386
+//go:build !linux
 
-package main
+package timesync
 
 import (
-	"syscall"
-	"time"
+	"errors"
+	"net"
 )
 
-func setSystemDate(t time.Time, adj int64, test bool) error {
-	// s := fmt.Sprintf("@%d", t.Unix())
-	// args = []string{"-s", s}
-	// err = exec.Command(date, args...).Run()
-	var tv syscall.Timeval
-	tv.Sec = int32(t.Unix())
-	tv.Usec = int32((t.UnixMilli()%1000 + adj) * 1000)
-	if test {
-		return nil
-	} else {
-		return syscall.Settimeofday(&tv)
-	}
+// wrap always fails, kernel receive timestamps are only read on Linux and
+// the query falls back to the time the answer was read.
+func (ts *rxTimestamp) wrap(conn net.Conn) (net.Conn, error) {
+	return nil, errors.New("kernel timestamping is not supported on this platform")
 }
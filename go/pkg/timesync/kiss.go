@@ -0,0 +1,73 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package timesync
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// rateBackoff is the extra delay applied before retrying after a RATE
+// kiss-o'-death.
+const rateBackoff = 2 * time.Second
+
+// kissError is returned when a server answers with a kiss-o'-death packet
+// (RFC 5905 section 7.4).
+type kissError struct {
+	server string
+	code   string
+}
+
+func (e *kissError) Error() string {
+	return fmt.Sprintf("server %s sent kiss-o'-death %s", e.server, e.code)
+}
+
+// handleKiss reacts to a kiss-o'-death carried by err. DENY and RSTR take the
+// server out of the rotation for the rest of the run by adding it to denied,
+// RATE returns how long to back off before the next query. Joined errors are
+// walked so every kiss they carry is handled, other errors are ignored.
+func handleKiss(err error, denied map[string]bool) time.Duration {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		var backoff time.Duration
+		for _, e := range joined.Unwrap() {
+			backoff = max(backoff, handleKiss(e, denied))
+		}
+		return backoff
+	}
+	var kiss *kissError
+	if !errors.As(err, &kiss) {
+		return 0
+	}
+	switch kiss.code {
+	case "DENY", "RSTR":
+		slog.Warn("Server denied access, removed from rotation", "server", kiss.server, "code", kiss.code)
+		denied[kiss.server] = true
+	case "RATE":
+		slog.Warn("Server asked to reduce rate, backing off", "server", kiss.server, "backoff", rateBackoff)
+		return rateBackoff
+	}
+	return 0
+}
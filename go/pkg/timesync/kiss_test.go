@@ -0,0 +1,105 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package timesync
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+)
+
+// startKissServer answers every query on a loopback UDP port with a
+// kiss-o'-death carrying code, built like ntpd does: stratum 0, the code as
+// reference identifier, and the transmit time of the query echoed as
+// origin, receive and transmit times. The beevik/ntp queries send a random
+// transmit time, the offset of the answer is garbage. It returns the
+// address of the server and the count of queries received.
+func startKissServer(t *testing.T, code string) (string, *atomic.Int32) {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	var queries atomic.Int32
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if n < 48 {
+				continue
+			}
+			queries.Add(1)
+			answer := make([]byte, 48)
+			answer[0] = 3<<6 | buf[0]&0x38 | 4 // alarm, version of the query, server mode
+			copy(answer[12:16], code)
+			copy(answer[24:32], buf[40:48])
+			copy(answer[32:40], buf[40:48])
+			copy(answer[40:48], buf[40:48])
+			conn.WriteToUDP(answer, addr)
+		}
+	}()
+	return conn.LocalAddr().String(), &queries
+}
+
+func TestKissOfDeathGarbageOffset(t *testing.T) {
+	for _, code := range []string{"DENY", "RSTR", "RATE"} {
+		t.Run(code, func(t *testing.T) {
+			server, _ := startKissServer(t, code)
+			opts := (Options{Test: true, RetryDelay: -1}).withDefaults()
+			_, err := timeSync(context.Background(), server, 0, &opts)
+			var kiss *kissError
+			if !errors.As(err, &kiss) || kiss.code != code {
+				t.Fatalf("timeSync error = %v, want a %s kiss-o'-death", err, code)
+			}
+			if errors.Is(err, ErrBadYear) {
+				t.Errorf("kiss-o'-death reported as a bad year: %v", err)
+			}
+			denied := map[string]bool{}
+			backoff := handleKiss(err, denied)
+			if want := code != "RATE"; denied[server] != want {
+				t.Errorf("denied = %v, want %v", denied[server], want)
+			}
+			if want := code == "RATE"; (backoff == rateBackoff) != want {
+				t.Errorf("backoff = %v", backoff)
+			}
+		})
+	}
+}
+
+func TestKissOfDeathDeniedNotQueriedAgain(t *testing.T) {
+	server, queries := startKissServer(t, "DENY")
+	_, err := Sync(context.Background(), Options{Servers: []string{server}, Test: true, Retries: 3, RetryDelay: -1})
+	if err == nil {
+		t.Fatal("Sync succeeded against a denying server")
+	}
+	if n := queries.Load(); n != 1 {
+		t.Errorf("%d queries, want 1: a denied server is not queried again", n)
+	}
+}
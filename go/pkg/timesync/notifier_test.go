@@ -0,0 +1,97 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package timesync
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/beevik/ntp"
+)
+
+// spyNotifier is a Notifier recording the messages it receives, each
+// prefixed with its level.
+type spyNotifier struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (s *spyNotifier) record(level string, m string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = append(s.messages, level+": "+m)
+	return nil
+}
+
+func (s *spyNotifier) Info(m string) error    { return s.record("info", m) }
+func (s *spyNotifier) Notice(m string) error  { return s.record("notice", m) }
+func (s *spyNotifier) Warning(m string) error { return s.record("warning", m) }
+func (s *spyNotifier) Err(m string) error     { return s.record("err", m) }
+
+// has reports whether a message starts with prefix.
+func (s *spyNotifier) has(prefix string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range s.messages {
+		if strings.HasPrefix(m, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestNotifierMessages(t *testing.T) {
+	tests := []struct {
+		name    string
+		answer  *ntp.Response
+		verbose bool
+		want    []string
+	}{
+		{"step", fakeAnswer(3 * time.Second), false, []string{"info: System time set to network time"}},
+		{"in sync verbose", fakeAnswer(time.Millisecond), true, []string{"info: NTP server=ntp.test addr=192.0.2.1", "info: Already in sync"}},
+		{"in sync quiet", fakeAnswer(time.Millisecond), false, nil},
+		{"unusable stratum", &ntp.Response{Stratum: 16}, false, []string{"err: Server ntp.test has unusable stratum 16", "err: NTP query failed after 1 attempts"}},
+		{"leap second", &ntp.Response{Time: time.Now(), Stratum: 2, Leap: ntp.LeapAddSecond}, false, []string{"notice: Server ntp.test announces a leap second"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spy := &spyNotifier{}
+			opts := offlineOptions(func() (*ntp.Response, error) { return tt.answer, nil }, nil)
+			opts.Notifier = spy
+			opts.Verbose = tt.verbose
+			Sync(context.Background(), opts)
+			for _, want := range tt.want {
+				if !spy.has(want) {
+					t.Errorf("no %q message in %q", want, spy.messages)
+				}
+			}
+			if tt.want == nil && len(spy.messages) != 0 {
+				t.Errorf("messages %q, want none", spy.messages)
+			}
+		})
+	}
+}
@@ -0,0 +1,353 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package timesync
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// Network Time Security (RFC 8915). A TLS 1.3 key exchange on TCP/4460
+// yields the keys and cookies authenticating the NTP exchanges, which then
+// carry them in NTPv4 extension fields.
+const (
+	ntsKEPort        = 4460
+	ntsALPN          = "ntske/1"
+	ntsExporterLabel = "EXPORTER-network-time-security"
+	ntsProtocolNTPv4 = 0
+	ntsAEADSIV256    = 15 // AEAD_AES_SIV_CMAC_256
+	ntsCookieTarget  = 8  // cookies kept in hand, as recommended
+)
+
+// NTS-KE record types, section 4.1.
+const (
+	keEndOfMessage = 0
+	keNextProtocol = 1
+	keError        = 2
+	keWarning      = 3
+	keAEAD         = 4
+	keCookie       = 5
+	keServer       = 6
+	kePort         = 7
+	keCritical     = 0x8000
+)
+
+// NTP extension field types, section 5.7.
+const (
+	efUniqueID          = 0x0104
+	efCookie            = 0x0204
+	efCookiePlaceholder = 0x0304
+	efAuthenticator     = 0x0404
+)
+
+// ntsSession holds the result of an NTS key exchange.
+// Fields:
+// - c2s, s2c: AEAD keys of the client to server and server to client packets.
+// - host, port: NTP server to query, negotiated by the key exchange.
+// - cookies: Unused cookies, each one is sent once.
+type ntsSession struct {
+	c2s     []byte
+	s2c     []byte
+	host    string
+	port    string
+	mu      sync.Mutex
+	cookies [][]byte
+}
+
+// ntsKeyExchange runs NTS-KE with host, server given as host[:port] where
+// port defaults to 4460. The NTP server defaults to host on port ntpPort
+// unless the key exchange negotiates another one.
+func ntsKeyExchange(ctx context.Context, server string, ntpPort string, opts *Options) (*ntsSession, error) {
+	host, port := splitServer(server, ntsKEPort)
+	kctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+	network := "tcp"
+	if opts.IPv4Only {
+		network = "tcp4"
+	} else if opts.IPv6Only {
+		network = "tcp6"
+	}
+	dialer := tls.Dialer{NetDialer: &net.Dialer{LocalAddr: opts.localTCPAddr()}, Config: &tls.Config{
+		ServerName: host,
+		NextProtos: []string{ntsALPN},
+		MinVersion: tls.VersionTLS13,
+	}}
+	conn, err := dialer.DialContext(kctx, network, net.JoinHostPort(host, port))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := kctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	state := conn.(*tls.Conn).ConnectionState()
+	if state.NegotiatedProtocol != ntsALPN {
+		return nil, fmt.Errorf("%s did not negotiate %s", host, ntsALPN)
+	}
+
+	var req bytes.Buffer
+	writeKERecord(&req, keNextProtocol|keCritical, binary.BigEndian.AppendUint16(nil, ntsProtocolNTPv4))
+	writeKERecord(&req, keAEAD|keCritical, binary.BigEndian.AppendUint16(nil, ntsAEADSIV256))
+	writeKERecord(&req, keEndOfMessage|keCritical, nil)
+	if _, err := conn.Write(req.Bytes()); err != nil {
+		return nil, err
+	}
+
+	session := &ntsSession{host: host, port: ntpPort}
+	if err := session.readKEResponse(conn); err != nil {
+		return nil, fmt.Errorf("NTS-KE with %s: %w", host, err)
+	}
+	for _, dir := range []struct {
+		key  *[]byte
+		code byte
+	}{{&session.c2s, 0}, {&session.s2c, 1}} {
+		exporterContext := []byte{0, ntsProtocolNTPv4, 0, ntsAEADSIV256, dir.code}
+		*dir.key, err = state.ExportKeyingMaterial(ntsExporterLabel, exporterContext, sivKeySize)
+		if err != nil {
+			return nil, err
+		}
+	}
+	slog.Debug("NTS key exchange done", "server", host, "ntp_server", session.host, "ntp_port", session.port, "cookies", len(session.cookies))
+	return session, nil
+}
+
+// readKEResponse parses the records of the key exchange response up to the
+// end of message record.
+func (s *ntsSession) readKEResponse(r io.Reader) error {
+	protocol, aead := false, false
+	header := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return err
+		}
+		typ := binary.BigEndian.Uint16(header)
+		body := make([]byte, binary.BigEndian.Uint16(header[2:]))
+		if _, err := io.ReadFull(r, body); err != nil {
+			return err
+		}
+		switch typ &^ keCritical {
+		case keEndOfMessage:
+			if !protocol || !aead || len(s.cookies) == 0 {
+				return errors.New("incomplete response, missing protocol, algorithm or cookies")
+			}
+			return nil
+		case keNextProtocol:
+			if len(body) != 2 || binary.BigEndian.Uint16(body) != ntsProtocolNTPv4 {
+				return errors.New("server does not support NTPv4")
+			}
+			protocol = true
+		case keAEAD:
+			if len(body) != 2 || binary.BigEndian.Uint16(body) != ntsAEADSIV256 {
+				return errors.New("server does not support AEAD_AES_SIV_CMAC_256")
+			}
+			aead = true
+		case keError:
+			return fmt.Errorf("server error %x", body)
+		case keWarning:
+			slog.Warn("NTS-KE warning", "code", fmt.Sprintf("%x", body))
+		case keCookie:
+			s.cookies = append(s.cookies, body)
+		case keServer:
+			s.host = string(body)
+		case kePort:
+			if len(body) != 2 {
+				return errors.New("invalid port record")
+			}
+			s.port = strconv.Itoa(int(binary.BigEndian.Uint16(body)))
+		default:
+			if typ&keCritical != 0 {
+				return fmt.Errorf("unknown critical record %d", typ&^keCritical)
+			}
+		}
+	}
+}
+
+// takeCookie returns a cookie for the next query and the number of extra
+// cookies to ask for to get back to ntsCookieTarget.
+func (s *ntsSession) takeCookie() ([]byte, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.cookies) == 0 {
+		return nil, 0
+	}
+	cookie := s.cookies[0]
+	s.cookies = s.cookies[1:]
+	return cookie, max(0, ntsCookieTarget-len(s.cookies)-1)
+}
+
+func (s *ntsSession) addCookie(cookie []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cookies = append(s.cookies, cookie)
+}
+
+// ntsExtension authenticates one NTP exchange of session with the NTS
+// extension fields, it is a beevik/ntp query extension.
+type ntsExtension struct {
+	session *ntsSession
+	uid     []byte
+}
+
+// ProcessQuery appends the unique identifier, a cookie, placeholders asking
+// for more cookies and the authenticator of all of it.
+func (e *ntsExtension) ProcessQuery(buf *bytes.Buffer) error {
+	cookie, missing := e.session.takeCookie()
+	if cookie == nil {
+		return errors.New("no NTS cookie left")
+	}
+	e.uid = make([]byte, 32)
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(e.uid); err != nil {
+		return err
+	}
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	writeExtensionField(buf, efUniqueID, e.uid)
+	writeExtensionField(buf, efCookie, cookie)
+	for range missing {
+		writeExtensionField(buf, efCookiePlaceholder, make([]byte, len(cookie)))
+	}
+	sealed, err := sivSeal(e.session.c2s, nil, buf.Bytes(), nonce)
+	if err != nil {
+		return err
+	}
+	writeExtensionField(buf, efAuthenticator, authenticator(nonce, sealed))
+	return nil
+}
+
+// ProcessResponse fails unless the response echoes the unique identifier
+// and carries a valid authenticator. The cookies it holds are kept for the
+// next queries. Extension fields after the authenticator are ignored.
+func (e *ntsExtension) ProcessResponse(buf []byte) error {
+	const headerSize = 48
+	if len(buf) >= headerSize && buf[1] == 0 && string(buf[12:16]) == "NTSN" {
+		return fmt.Errorf("%w: NTS cookie refused (NTSN)", ErrRejected)
+	}
+	uid := false
+	for pos := headerSize; pos+4 <= len(buf); {
+		typ := binary.BigEndian.Uint16(buf[pos:])
+		length := int(binary.BigEndian.Uint16(buf[pos+2:]))
+		if length < 4 || pos+length > len(buf) {
+			break
+		}
+		body := buf[pos+4 : pos+length]
+		switch typ {
+		case efUniqueID:
+			uid = subtle.ConstantTimeCompare(body, e.uid) == 1
+		case efAuthenticator:
+			if !uid {
+				return fmt.Errorf("%w: NTS response does not match the query", ErrRejected)
+			}
+			nonce, sealed, ok := parseAuthenticator(body)
+			if !ok {
+				return fmt.Errorf("%w: malformed NTS authenticator", ErrRejected)
+			}
+			plaintext, err := sivOpen(e.session.s2c, sealed, buf[:pos], nonce)
+			if err != nil {
+				return fmt.Errorf("%w: NTS response: %w", ErrRejected, err)
+			}
+			for _, ef := range extensionFields(plaintext) {
+				if ef.typ == efCookie {
+					e.session.addCookie(ef.body)
+				}
+			}
+			return nil
+		}
+		pos += length
+	}
+	return fmt.Errorf("%w: NTS response is not authenticated", ErrRejected)
+}
+
+// writeKERecord appends an NTS-KE record.
+func writeKERecord(buf *bytes.Buffer, typ uint16, body []byte) {
+	binary.Write(buf, binary.BigEndian, typ)
+	binary.Write(buf, binary.BigEndian, uint16(len(body)))
+	buf.Write(body)
+}
+
+// writeExtensionField appends an NTP extension field, its body zero padded
+// to a multiple of 4 bytes.
+func writeExtensionField(buf *bytes.Buffer, typ uint16, body []byte) {
+	padded := (len(body) + 3) &^ 3
+	binary.Write(buf, binary.BigEndian, typ)
+	binary.Write(buf, binary.BigEndian, uint16(4+padded))
+	buf.Write(body)
+	buf.Write(make([]byte, padded-len(body)))
+}
+
+type extensionField struct {
+	typ  uint16
+	body []byte
+}
+
+// extensionFields splits a sequence of extension fields, stopping at the
+// first malformed one.
+func extensionFields(buf []byte) []extensionField {
+	var fields []extensionField
+	for pos := 0; pos+4 <= len(buf); {
+		length := int(binary.BigEndian.Uint16(buf[pos+2:]))
+		if length < 4 || pos+length > len(buf) {
+			break
+		}
+		fields = append(fields, extensionField{typ: binary.BigEndian.Uint16(buf[pos:]), body: buf[pos+4 : pos+length]})
+		pos += length
+	}
+	return fields
+}
+
+// authenticator builds the body of an NTS authenticator extension field.
+func authenticator(nonce []byte, sealed []byte) []byte {
+	body := binary.BigEndian.AppendUint16(nil, uint16(len(nonce)))
+	body = binary.BigEndian.AppendUint16(body, uint16(len(sealed)))
+	body = append(body, nonce...)
+	body = append(body, make([]byte, (len(nonce)+3)&^3-len(nonce))...)
+	return append(body, sealed...)
+}
+
+// parseAuthenticator splits the body of an NTS authenticator extension field
+// into nonce and ciphertext.
+func parseAuthenticator(body []byte) ([]byte, []byte, bool) {
+	if len(body) < 4 {
+		return nil, nil, false
+	}
+	nonceLen := int(binary.BigEndian.Uint16(body))
+	sealedLen := int(binary.BigEndian.Uint16(body[2:]))
+	start := 4 + (nonceLen+3)&^3
+	if start+sealedLen > len(body) {
+		return nil, nil, false
+	}
+	return body[4 : 4+nonceLen], body[start : start+sealedLen], true
+}
@@ -0,0 +1,285 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package timesync
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// keResponse builds an NTS-KE response out of records.
+func keResponse(records ...func(*bytes.Buffer)) []byte {
+	var buf bytes.Buffer
+	for _, record := range records {
+		record(&buf)
+	}
+	return buf.Bytes()
+}
+
+func keRecord(typ uint16, body ...byte) func(*bytes.Buffer) {
+	return func(buf *bytes.Buffer) { writeKERecord(buf, typ, body) }
+}
+
+var (
+	keProtocolOK = keRecord(keNextProtocol|keCritical, 0, ntsProtocolNTPv4)
+	keAEADOK     = keRecord(keAEAD|keCritical, 0, ntsAEADSIV256)
+	keCookieOK   = keRecord(keCookie, 'c', 'o', 'o', 'k', 'i', 'e')
+	keEnd        = keRecord(keEndOfMessage | keCritical)
+)
+
+func TestReadKEResponse(t *testing.T) {
+	valid := keResponse(keProtocolOK, keAEADOK, keCookieOK, keCookieOK, keEnd)
+	tests := []struct {
+		name     string
+		response []byte
+		err      string
+	}{
+		{"valid", valid, ""},
+		{"warning and unknown record ignored", keResponse(keProtocolOK, keRecord(keWarning, 0, 1), keRecord(0x4000, 1, 2, 3), keAEADOK, keCookieOK, keEnd), ""},
+		{"empty", nil, io.EOF.Error()},
+		{"truncated header", valid[:len(valid)-2], io.ErrUnexpectedEOF.Error()},
+		{"truncated body", valid[:11], io.ErrUnexpectedEOF.Error()},
+		{"oversized length", append([]byte{0, keCookie, 0xff, 0xff}, valid...), io.ErrUnexpectedEOF.Error()},
+		{"no end of message", keResponse(keProtocolOK, keAEADOK, keCookieOK), io.EOF.Error()},
+		{"no cookie", keResponse(keProtocolOK, keAEADOK, keEnd), "incomplete response"},
+		{"no protocol", keResponse(keAEADOK, keCookieOK, keEnd), "incomplete response"},
+		{"no algorithm", keResponse(keProtocolOK, keCookieOK, keEnd), "incomplete response"},
+		{"other protocol", keResponse(keRecord(keNextProtocol|keCritical, 0x80, 1), keEnd), "does not support NTPv4"},
+		{"long protocol", keResponse(keRecord(keNextProtocol|keCritical, 0, 0, 0), keEnd), "does not support NTPv4"},
+		{"other algorithm", keResponse(keProtocolOK, keRecord(keAEAD|keCritical, 0, 30), keEnd), "does not support AEAD_AES_SIV_CMAC_256"},
+		{"error record", keResponse(keRecord(keError|keCritical, 0, 1)), "server error 0001"},
+		{"unknown critical record", keResponse(keProtocolOK, keRecord(0x4000|keCritical), keEnd), "unknown critical record 16384"},
+		{"short port", keResponse(keProtocolOK, keRecord(kePort|keCritical, 1), keEnd), "invalid port record"},
+		{"long port", keResponse(keProtocolOK, keRecord(kePort|keCritical, 0, 1, 2), keEnd), "invalid port record"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session := &ntsSession{}
+			err := session.readKEResponse(bytes.NewReader(tt.response))
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("readKEResponse error = %v", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Fatalf("readKEResponse error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestReadKEResponseSession(t *testing.T) {
+	session := &ntsSession{host: "ke.example", port: "123"}
+	response := keResponse(keProtocolOK, keAEADOK, keCookieOK, keRecord(keCookie, 'x'),
+		keRecord(keServer, []byte("ntp.example")...), keRecord(kePort, 0x04, 0xd2), keEnd)
+	if err := session.readKEResponse(bytes.NewReader(response)); err != nil {
+		t.Fatal(err)
+	}
+	if session.host != "ntp.example" || session.port != "1234" {
+		t.Errorf("server = %s:%s, want ntp.example:1234", session.host, session.port)
+	}
+	if len(session.cookies) != 2 || string(session.cookies[0]) != "cookie" || string(session.cookies[1]) != "x" {
+		t.Errorf("cookies = %q", session.cookies)
+	}
+}
+
+func TestExtensionFields(t *testing.T) {
+	var buf bytes.Buffer
+	writeExtensionField(&buf, efUniqueID, []byte{1, 2, 3, 4, 5})
+	writeExtensionField(&buf, efCookie, nil)
+	valid := buf.Bytes()
+	if len(valid) != 12+4 {
+		t.Fatalf("fields are %d bytes, want 16 with padding", len(valid))
+	}
+	tests := []struct {
+		name  string
+		buf   []byte
+		types []uint16
+	}{
+		{"valid", valid, []uint16{efUniqueID, efCookie}},
+		{"empty", nil, nil},
+		{"truncated header", valid[:len(valid)-2], []uint16{efUniqueID}},
+		{"truncated body", valid[:11], nil},
+		{"oversized length", append([]byte{0x01, 0x04, 0xff, 0xfc}, valid...), nil},
+		{"length below header", append(append([]byte(nil), valid[:12]...), 0x02, 0x04, 0, 3), []uint16{efUniqueID}},
+		{"zero length", append([]byte{0x01, 0x04, 0, 0}, valid...), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields := extensionFields(tt.buf)
+			if len(fields) != len(tt.types) {
+				t.Fatalf("got %d fields, want %d", len(fields), len(tt.types))
+			}
+			for i, ef := range fields {
+				if ef.typ != tt.types[i] {
+					t.Errorf("field %d type = %#x, want %#x", i, ef.typ, tt.types[i])
+				}
+			}
+		})
+	}
+	if fields := extensionFields(valid); !bytes.Equal(fields[0].body, []byte{1, 2, 3, 4, 5, 0, 0, 0}) {
+		t.Errorf("padded body = %x", fields[0].body)
+	}
+}
+
+func TestParseAuthenticator(t *testing.T) {
+	nonce, sealed := []byte{1, 2, 3, 4, 5, 6}, bytes.Repeat([]byte{9}, 16)
+	body := authenticator(nonce, sealed)
+	if len(body) != 4+8+16 {
+		t.Fatalf("authenticator is %d bytes, want 28 with padding", len(body))
+	}
+	gotNonce, gotSealed, ok := parseAuthenticator(body)
+	if !ok || !bytes.Equal(gotNonce, nonce) || !bytes.Equal(gotSealed, sealed) {
+		t.Fatalf("parseAuthenticator = %x, %x, %v", gotNonce, gotSealed, ok)
+	}
+
+	withLengths := func(nonceLen, sealedLen uint16) []byte {
+		b := bytes.Clone(body)
+		binary.BigEndian.PutUint16(b, nonceLen)
+		binary.BigEndian.PutUint16(b[2:], sealedLen)
+		return b
+	}
+	tests := []struct {
+		name string
+		body []byte
+	}{
+		{"empty", nil},
+		{"truncated header", body[:3]},
+		{"truncated ciphertext", body[:len(body)-1]},
+		{"oversized nonce", withLengths(0xffff, 16)},
+		{"oversized ciphertext", withLengths(6, 0xffff)},
+		{"nonce past the end", withLengths(28, 0)},
+	}
+	for _, tt := range tests {
+		if _, _, ok := parseAuthenticator(tt.body); ok {
+			t.Errorf("%s: parseAuthenticator accepted %x", tt.name, tt.body)
+		}
+	}
+}
+
+// ntsTestSession returns a session with distinct keys in both directions
+// and one cookie.
+func ntsTestSession() *ntsSession {
+	return &ntsSession{
+		c2s:     bytes.Repeat([]byte{1}, sivKeySize),
+		s2c:     bytes.Repeat([]byte{2}, sivKeySize),
+		cookies: [][]byte{[]byte("cookie-1")},
+	}
+}
+
+func TestNTSProcessQuery(t *testing.T) {
+	session := ntsTestSession()
+	e := &ntsExtension{session: session}
+	buf := bytes.NewBuffer(make([]byte, 48))
+	if err := e.ProcessQuery(buf); err != nil {
+		t.Fatal(err)
+	}
+	fields := extensionFields(buf.Bytes()[48:])
+	if n := len(fields); n != 2+ntsCookieTarget-1+1 {
+		t.Fatalf("query has %d extension fields", n)
+	}
+	if fields[0].typ != efUniqueID || !bytes.Equal(fields[0].body, e.uid) {
+		t.Error("first field is not the unique identifier")
+	}
+	if fields[1].typ != efCookie || string(fields[1].body) != "cookie-1" {
+		t.Errorf("second field is %#x %q, want the cookie", fields[1].typ, fields[1].body)
+	}
+	auth := fields[len(fields)-1]
+	if auth.typ != efAuthenticator {
+		t.Fatalf("last field type = %#x, want the authenticator", auth.typ)
+	}
+	nonce, sealed, ok := parseAuthenticator(auth.body)
+	if !ok {
+		t.Fatal("malformed authenticator")
+	}
+	authenticated := buf.Bytes()[:buf.Len()-4-len(auth.body)]
+	if _, err := sivOpen(session.c2s, sealed, authenticated, nonce); err != nil {
+		t.Errorf("authenticator does not verify with the c2s key: %v", err)
+	}
+	if err := e.ProcessQuery(buf); err == nil {
+		t.Error("ProcessQuery succeeded without cookies left")
+	}
+}
+
+// ntsResponse builds a response to e carrying uid and an authenticator
+// sealed with key around the encrypted extension fields.
+func ntsResponse(t *testing.T, key []byte, uid []byte, encrypted []byte) []byte {
+	t.Helper()
+	buf := bytes.NewBuffer(make([]byte, 48))
+	writeExtensionField(buf, efUniqueID, uid)
+	nonce := bytes.Repeat([]byte{7}, 16)
+	sealed, err := sivSeal(key, encrypted, buf.Bytes(), nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeExtensionField(buf, efAuthenticator, authenticator(nonce, sealed))
+	return buf.Bytes()
+}
+
+func TestNTSProcessResponse(t *testing.T) {
+	uid := bytes.Repeat([]byte{5}, 32)
+	var cookies bytes.Buffer
+	writeExtensionField(&cookies, efCookie, []byte("fresh-1"))
+	writeExtensionField(&cookies, efCookie, []byte("fresh-2"))
+
+	t.Run("valid", func(t *testing.T) {
+		session := &ntsSession{s2c: ntsTestSession().s2c}
+		e := &ntsExtension{session: session, uid: uid}
+		if err := e.ProcessResponse(ntsResponse(t, session.s2c, uid, cookies.Bytes())); err != nil {
+			t.Fatal(err)
+		}
+		if len(session.cookies) != 2 || !bytes.HasPrefix(session.cookies[1], []byte("fresh-2")) {
+			t.Errorf("cookies = %q", session.cookies)
+		}
+	})
+
+	session := ntsTestSession()
+	valid := ntsResponse(t, session.s2c, uid, cookies.Bytes())
+	nak := make([]byte, 48)
+	copy(nak[12:], "NTSN")
+	tampered := bytes.Clone(valid)
+	tampered[len(tampered)-1] ^= 1
+	tests := []struct {
+		name string
+		buf  []byte
+	}{
+		{"NTS NAK", nak},
+		{"no extension field", valid[:48]},
+		{"other unique identifier", ntsResponse(t, session.s2c, bytes.Repeat([]byte{6}, 32), nil)},
+		{"wrong key", ntsResponse(t, session.c2s, uid, nil)},
+		{"ciphertext tampered", tampered},
+		{"truncated", valid[:len(valid)-4]},
+		{"oversized field", append(bytes.Clone(valid[:48]), 0x01, 0x04, 0xff, 0xfc)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &ntsExtension{session: session, uid: uid}
+			if err := e.ProcessResponse(tt.buf); !errors.Is(err, ErrRejected) {
+				t.Errorf("ProcessResponse error = %v, want %v", err, ErrRejected)
+			}
+		})
+	}
+}
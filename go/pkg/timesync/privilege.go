@@ -0,0 +1,61 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package timesync
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"runtime"
+	"time"
+)
+
+// reportSetError logs a failure to set the clock to t. Lacking privileges is
+// by far the most common cause, it gets a hint with the date command that
+// would have done the same as root.
+func reportSetError(err error, t time.Time, notifier Notifier) {
+	if errors.Is(err, ErrPermission) {
+		slog.Error("Permission denied setting the system time, run as root", "error", err)
+		fmt.Fprintf(os.Stderr, "Run as root, or set the clock by hand with:\n  sudo %s\n", dateCommand(t))
+		notifier.Err(fmt.Sprintf("Permission denied setting the system time: %v", err))
+		return
+	}
+	slog.Error("Failed to set system date", "error", err)
+	notifier.Err(fmt.Sprintf("Failed to set system date: %v", err))
+}
+
+// dateCommand returns the date(1) invocation setting the clock to t, in the
+// GNU syntax on Linux and with the operand of dateLayout elsewhere.
+func dateCommand(t time.Time) string {
+	return dateCommandFor(runtime.GOOS, t)
+}
+
+// dateCommandFor is dateCommand on goos.
+func dateCommandFor(goos string, t time.Time) string {
+	if goos == "linux" {
+		return fmt.Sprintf("date -u -s @%d", t.Unix())
+	}
+	return "date -u " + t.UTC().Format(dateLayout(goos))
+}
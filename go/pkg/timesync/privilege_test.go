@@ -0,0 +1,50 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package timesync
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDateCommand(t *testing.T) {
+	at := time.Date(2026, 3, 4, 5, 6, 7, 0, time.FixedZone("CET", 3600))
+	tests := []struct {
+		goos string
+		want string
+	}{
+		{"linux", "date -u -s @1772597167"},
+		{"freebsd", "date -u 202603040406.07"},
+		{"openbsd", "date -u 202603040406.07"},
+		{"netbsd", "date -u 202603040406.07"},
+		{"dragonfly", "date -u 202603040406.07"},
+		{"darwin", "date -u 030404062026.07"},
+		{"solaris", "date -u 030404062026.07"},
+	}
+	for _, tt := range tests {
+		if got := dateCommandFor(tt.goos, at); got != tt.want {
+			t.Errorf("%s: %q, want %q", tt.goos, got, tt.want)
+		}
+	}
+}
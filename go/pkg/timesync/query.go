@@ -0,0 +1,569 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package timesync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/beevik/ntp"
+)
+
+// ntpSample holds one NTP exchange together with the local clock readings
+// taken around it.
+type ntpSample struct {
+	server   string
+	ip       string
+	response *ntp.Response
+	sent     time.Time     // local time just before the query, with its monotonic reading
+	prepoch  int64         // local time in ms before the query
+	nowpoch  int64         // local time in ms after the query
+	took     time.Duration // duration of the query, immune to wall clock jumps
+	jitter   time.Duration // offset jitter across the samples it was chosen from, zero for one
+}
+
+// timeSync synchronizes the system time with the given NTP server.
+// It performs the following steps:
+// 1. Resolves the IP address of the NTP server.
+// 2. Retrieves the current time from the NTP server.
+// 3. Checks if the retrieved time is valid (year >= 2025).
+// 4. Calculates the time difference between the system time and the NTP time.
+// 5. If the time difference is significant, it adjusts the system time.
+// 6. Logs the results and any errors encountered.
+//
+// Parameters:
+// - ctx: Cancels the DNS lookup and the NTP query.
+// - server: The NTP server to synchronize with.
+// - attempt: The retry number, successive attempts start on different addresses.
+// - opts: The synchronization settings, Test runs without setting the system time.
+//
+// Returns a summary of the exchange, and an error if any step fails.
+func timeSync(ctx context.Context, server string, attempt int, opts *Options) (Result, error) {
+	sample, err := queryServer(ctx, server, attempt, opts)
+	if err != nil {
+		return Result{Server: server}, err
+	}
+	return applySample(sample, opts)
+}
+
+// queryServer resolves the NTP server and queries its addresses in turn
+// until one answers, restricted to one address family when -4 or -6 is
+// given. Each attempt starts one address further, so retries against a pool
+// name spread over its hosts. It only fails once every address has failed or
+// ctx is done. The DNS lookup and each query are bounded by the timeout of
+// the server.
+func queryServer(ctx context.Context, server string, attempt int, opts *Options) (*ntpSample, error) {
+	opts = opts.forServer(server)
+	notifier := opts.Notifier
+	host, port := splitServer(server, opts.Port)
+	if opts.NTS {
+		// The port given with the server is the NTS-KE one, the NTP
+		// server and port come from the key exchange.
+		session, err := ntsKeyExchange(ctx, server, strconv.Itoa(opts.Port), opts)
+		if err != nil {
+			slog.Error("NTS key exchange failed", "server", server, "error", err)
+			notifier.Err(fmt.Sprintf("NTS key exchange with %s failed: %v", server, err))
+			return nil, err
+		}
+		host, port = session.host, session.port
+		o := *opts
+		o.nts = session
+		opts = &o
+	}
+	ips, err := resolveServer(ctx, host, opts)
+	if err != nil {
+		slog.Error("Could not get IPs:", "error", err)
+		notifier.Err(fmt.Sprintf("Could not get IPs: %v\n", err))
+		return nil, err
+	}
+	ips = filterIPs(ips, opts.IPv4Only, opts.IPv6Only)
+	if len(ips) == 0 {
+		slog.Error("No address in the requested family", "server", server, "ipv4", opts.IPv4Only, "ipv6", opts.IPv6Only)
+		notifier.Err(fmt.Sprintf("No address in the requested family for %s", server))
+		return nil, fmt.Errorf("%w: no address in the requested family for %s", ErrQueryFailed, server)
+	}
+
+	// Every address is tried before giving up, a pool name resolving to
+	// several hosts should not fail because its first one is down.
+	first := attempt % len(ips)
+	addrs := make([]string, 0, len(ips))
+	for i := range ips {
+		addrs = append(addrs, ips[(first+i)%len(ips)].String())
+	}
+	var errs []error
+	// On a dual-stack host one family may be broken, the first address of
+	// each is raced and the other addresses only tried if both fail.
+	if v4, v6 := firstOfFamilies(addrs); v4 != "" && v6 != "" {
+		slog.Debug("Racing address families", "name", server, "ipv4", v4, "ipv6", v6, "port", port, "attempt", attempt+1)
+		sample, raceErrs := raceQuery(ctx, server, []string{v4, v6}, port, opts)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if sample != nil {
+			return refineSample(ctx, sample, port, opts), nil
+		}
+		errs = append(errs, raceErrs...)
+		addrs = slices.DeleteFunc(addrs, func(ip string) bool { return ip == v4 || ip == v6 })
+	}
+	for _, serverIP := range addrs {
+		slog.Debug("Server", "name", server, "ip", serverIP, "port", port, "attempt", attempt+1)
+		sample, err := queryAddress(ctx, server, serverIP, port, opts)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", serverIP, err))
+			continue
+		}
+		return refineSample(ctx, sample, port, opts), nil
+	}
+	err = errors.Join(errs...)
+	slog.Error("Failed to query NTP server", "server", server, "addresses", len(ips), "error", err)
+	notifier.Err(fmt.Sprintf("Failed to query NTP server %s on %d addresses: %v", server, len(ips), err))
+	return nil, fmt.Errorf("%w: %w", ErrQueryFailed, err)
+}
+
+// resolveServer returns the addresses of host. With NoDNS host must be an
+// IP address and the resolver is never used.
+func resolveServer(ctx context.Context, host string, opts *Options) ([]net.IPAddr, error) {
+	if opts.NoDNS {
+		ip, ok := parseIPZone(host)
+		if !ok {
+			return nil, fmt.Errorf("%s is not an IP address and DNS is disabled", host)
+		}
+		return []net.IPAddr{ip}, nil
+	}
+	lctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+	return opts.LookupIPAddr(lctx, host)
+}
+
+// refineSample takes the extra samples asked for by -burst or -samples from
+// the address that answered.
+func refineSample(ctx context.Context, sample *ntpSample, port string, opts *Options) *ntpSample {
+	slog.Debug("Query succeeded", "server", sample.server, "ip", sample.ip)
+	// The first query paid for the cold DNS, ARP and socket caches, the
+	// next one measures a warm path.
+	if opts.Warmup {
+		slog.Debug("Warmup query performed, discarding it", "server", sample.server, "ip", sample.ip, "rtt", sample.response.RTT)
+		warm, err := queryAddress(ctx, sample.server, sample.ip, port, opts)
+		if err != nil {
+			slog.Debug("Query after warmup failed, keeping the warmup one", "ip", sample.ip, "error", err)
+		} else {
+			sample = warm
+		}
+	}
+	if opts.Burst {
+		return burstSample(ctx, sample, port, opts)
+	} else if opts.Samples > 1 {
+		return bestSample(ctx, sample, port, opts)
+	}
+	return sample
+}
+
+// firstOfFamilies returns the first IPv4 and the first IPv6 address of
+// addrs, empty when the family is missing.
+func firstOfFamilies(addrs []string) (string, string) {
+	var v4, v6 string
+	for _, addr := range addrs {
+		ip, _ := parseIPZone(addr)
+		isV4 := ip.IP.To4() != nil
+		if isV4 && v4 == "" {
+			v4 = addr
+		} else if !isV4 && v6 == "" {
+			v6 = addr
+		}
+	}
+	return v4, v6
+}
+
+// raceQuery queries addrs of server concurrently and returns the first
+// answer. The slower queries are cancelled, which closes their sockets.
+// Without any answer it returns the error of every address.
+func raceQuery(ctx context.Context, server string, addrs []string, port string, opts *Options) (*ntpSample, []error) {
+	rctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type answer struct {
+		ip     string
+		sample *ntpSample
+		err    error
+	}
+	answers := make(chan answer, len(addrs))
+	for _, ip := range addrs {
+		go func() {
+			sample, err := queryAddress(rctx, server, ip, port, opts)
+			answers <- answer{ip: ip, sample: sample, err: err}
+		}()
+	}
+	var errs []error
+	for range addrs {
+		a := <-answers
+		if a.err == nil {
+			slog.Debug("Address family race won", "server", server, "ip", a.ip)
+			return a.sample, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", a.ip, a.err))
+	}
+	return nil, errs
+}
+
+// queryAddress performs a single NTP exchange with one address of server.
+func queryAddress(ctx context.Context, server string, serverIP string, port string, opts *Options) (*ntpSample, error) {
+	notifier := opts.Notifier
+	if !opts.budget.take() {
+		slog.Debug("Query budget exhausted", "ip", serverIP, "max_queries", opts.MaxQueries)
+		return nil, ErrQueryBudget
+	}
+
+	// Query NTP with timeout
+	qctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+	var stamp *rxTimestamp
+	if opts.KernelTimestamp {
+		stamp = &rxTimestamp{}
+	}
+	options := ntp.QueryOptions{Version: opts.Version, Timeout: opts.Timeout, Auth: opts.Auth, LocalAddress: opts.LocalAddress, Dialer: contextDialer(qctx, stamp)}
+	if opts.nts != nil {
+		options.Extensions = []ntp.Extension{&ntsExtension{session: opts.nts}}
+	}
+	sent := time.Now()
+	response, err := opts.Query(net.JoinHostPort(serverIP, port), options)
+	if err != nil {
+		slog.Debug("Address did not answer", "ip", serverIP, "error", err)
+		return nil, err
+	}
+	// beevik/ntp still returns the response when the MAC does not
+	// match, never trust it in that case.
+	if opts.Auth.Type != ntp.AuthNone && response.Validate() == ntp.ErrAuthFailed {
+		slog.Warn("Authentication failed", "ip", serverIP, "keyid", opts.Auth.KeyID)
+		notifier.Warning(fmt.Sprintf("NTP authentication failed for %s (%s)", server, serverIP))
+		return nil, ntp.ErrAuthFailed
+	}
+	stamp.correct(response)
+	return &ntpSample{
+		server:   server,
+		ip:       serverIP,
+		response: response,
+		sent:     sent,
+		prepoch:  sent.UnixMilli(),
+		nowpoch:  time.Now().UnixMilli(),
+		took:     time.Since(sent),
+	}, nil
+}
+
+// splitServer splits a server argument into host and port. Bare hostnames
+// and IPv6 literals without brackets use defaultPort.
+func splitServer(server string, defaultPort int) (string, string) {
+	host, port, err := net.SplitHostPort(server)
+	if err != nil {
+		return server, strconv.Itoa(defaultPort)
+	}
+	return host, port
+}
+
+// contextDialer returns a beevik/ntp dialer whose connection is closed when
+// ctx is done, which aborts the pending read of the query. With stamp, the
+// kernel receive time of the answer is recorded where supported.
+func contextDialer(ctx context.Context, stamp *rxTimestamp) func(localAddress, remoteAddress string) (net.Conn, error) {
+	return func(localAddress, remoteAddress string) (net.Conn, error) {
+		var d net.Dialer
+		if localAddress != "" {
+			ip, _ := parseIPZone(localAddress)
+			d.LocalAddr = &net.UDPAddr{IP: ip.IP, Zone: ip.Zone}
+		}
+		conn, err := d.DialContext(ctx, "udp", remoteAddress)
+		if err != nil {
+			if localAddress != "" {
+				return nil, fmt.Errorf("cannot bind to source address %s: %w", localAddress, err)
+			}
+			return nil, err
+		}
+		context.AfterFunc(ctx, func() { conn.Close() })
+		if stamp != nil {
+			tconn, err := stamp.wrap(conn)
+			if err != nil {
+				slog.Debug("Kernel timestamping unavailable, using the read time", "error", err)
+				return conn, nil
+			}
+			return tconn, nil
+		}
+		return conn, nil
+	}
+}
+
+// rxTimestamp holds how long the answer to a query waited in the socket
+// before beevik/ntp read it and took its receive time.
+// Fields:
+// - lag: Time between the kernel receive timestamp and the read, zero if unknown.
+type rxTimestamp struct {
+	lag time.Duration
+}
+
+// correct moves the receive time of response back to the kernel timestamp:
+// the roundtrip shrinks by the lag and the offset, computed with the
+// receive time subtracted, grows by half of it. A nil ts or an implausible
+// lag leaves response alone.
+func (ts *rxTimestamp) correct(response *ntp.Response) {
+	if ts == nil || ts.lag <= 0 || ts.lag >= response.RTT {
+		return
+	}
+	slog.Debug("Kernel receive timestamp", "lag", ts.lag)
+	response.RTT -= ts.lag
+	response.ClockOffset += ts.lag / 2
+}
+
+// localTCPAddr returns the address TCP connections are bound to, nil when
+// Options.LocalAddress is not set.
+func (opts *Options) localTCPAddr() net.Addr {
+	if opts.LocalAddress == "" {
+		return nil
+	}
+	ip, _ := parseIPZone(opts.LocalAddress)
+	return &net.TCPAddr{IP: ip.IP, Zone: ip.Zone}
+}
+
+// parseIPZone parses an IP address literal, keeping the zone of a scoped
+// IPv6 address such as fe80::1%eth0 that net.ParseIP refuses.
+func parseIPZone(s string) (net.IPAddr, bool) {
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return net.IPAddr{}, false
+	}
+	return net.IPAddr{IP: addr.AsSlice(), Zone: addr.Zone()}, true
+}
+
+// filterIPs keeps only the addresses of the requested family. With neither
+// flag set all addresses are kept in resolver order.
+func filterIPs(ips []net.IPAddr, v4only bool, v6only bool) []net.IPAddr {
+	if !v4only && !v6only {
+		return ips
+	}
+	var filtered []net.IPAddr
+	for _, ip := range ips {
+		isV4 := ip.IP.To4() != nil
+		if (v4only && isV4) || (v6only && !isV4) {
+			filtered = append(filtered, ip)
+		}
+	}
+	return filtered
+}
+
+// result returns the Result describing the sample, before anything is done
+// with it.
+func (sample *ntpSample) result() Result {
+	return Result{
+		Server:    sample.server,
+		IP:        sample.ip,
+		Offset:    sample.response.ClockOffset,
+		RTT:       sample.response.RTT,
+		Stratum:   sample.response.Stratum,
+		Precision: sample.response.Precision,
+	}
+}
+
+// serverTime returns the time of the server at the local instant nowpoch,
+// in milliseconds. The offset is derived from all four RFC 5905 timestamps
+// and already accounts for the network delay, so it applies to any local
+// instant without a roundtrip correction.
+func serverTime(nowpoch int64, offset time.Duration) time.Time {
+	return time.UnixMilli(nowpoch).Add(offset)
+}
+
+// applySample checks that an NTP sample is sane and steps the system clock
+// when the offset it measured is significant.
+func applySample(sample *ntpSample, opts *Options) (Result, error) {
+	notifier := opts.Notifier
+	test := opts.Test
+	response := sample.response
+	server := sample.server
+	serverIP := sample.ip
+	prepoch := sample.prepoch
+	nowpoch := sample.nowpoch
+	result := sample.result()
+	// Stratum 0 is a kiss-o'-death and 16 means the server itself is not
+	// synchronized, neither carries a usable time. They come first: a
+	// kiss-o'-death echoes the transmit time of the query, its offset is
+	// garbage that would fail the year check and hide the code.
+	if response.IsKissOfDeath() {
+		slog.Warn("Kiss-o'-death received", "server", server, "code", response.KissCode)
+		notifier.Warning(fmt.Sprintf("Kiss-o'-death %s received from %s", response.KissCode, server))
+		return result, &kissError{server: server, code: response.KissCode}
+	}
+	if response.Stratum == 0 || response.Stratum >= 16 {
+		slog.Error("Server stratum is unusable", "server", server, "stratum", response.Stratum)
+		notifier.Err(fmt.Sprintf("Server %s has unusable stratum %d", server, response.Stratum))
+		return result, fmt.Errorf("%w: server %s has stratum %d", ErrStratum, server, response.Stratum)
+	}
+
+	// On a known asymmetric path, correct the offset before anything
+	// relies on it.
+	if opts.Asymmetry != 0 || opts.AsymmetryFraction != 0 {
+		corrected := *response
+		corrected.ClockOffset = opts.correctAsymmetry(response.ClockOffset, response.RTT)
+		slog.Debug("Asymmetry correction", "server", server, "measured", response.ClockOffset, "corrected", corrected.ClockOffset)
+		response = &corrected
+		result.Offset = response.ClockOffset
+	}
+
+	ntime := serverTime(nowpoch, response.ClockOffset)
+	nyear := ntime.Year()
+	if nyear < 2025 || nyear > 2200 {
+		slog.Error("Year is out of valid range (2025-2200)", "year", nyear)
+		notifier.Err(fmt.Sprintf("Year is out of valid range (2025-2200): %v", nyear))
+		return result, fmt.Errorf("%w: %d", ErrBadYear, nyear)
+	}
+	// A leap indicator of 3 means the server lost its own synchronization,
+	// whatever its stratum says.
+	switch response.Leap {
+	case ntp.LeapNotInSync:
+		slog.Error("Server is not synchronized", "server", server, "leap", response.Leap)
+		notifier.Err(fmt.Sprintf("Server %s is not synchronized (leap indicator 3)", server))
+		return result, fmt.Errorf("%w: server %s is not synchronized", ErrRejected, server)
+	case ntp.LeapAddSecond, ntp.LeapDelSecond:
+		slog.Info("Leap second announced for the end of the month", "server", server, "insert", response.Leap == ntp.LeapAddSecond)
+		notifier.Notice(fmt.Sprintf("Server %s announces a leap second at the end of the month", server))
+	}
+	if opts.MaxStratum > 0 && int(response.Stratum) > opts.MaxStratum {
+		slog.Error("Server stratum is above the maximum", "server", server, "stratum", response.Stratum, "max", opts.MaxStratum)
+		notifier.Err(fmt.Sprintf("Server %s stratum %d is above the maximum %d", server, response.Stratum, opts.MaxStratum))
+		return result, fmt.Errorf("%w: server %s stratum %d is above the maximum %d", ErrStratum, server, response.Stratum, opts.MaxStratum)
+	}
+	if opts.MaxRootDispersion > 0 && response.RootDispersion > opts.MaxRootDispersion {
+		slog.Error("Server root dispersion is above the maximum", "server", server, "root_dispersion", response.RootDispersion, "max", opts.MaxRootDispersion)
+		notifier.Err(fmt.Sprintf("Server %s root dispersion %v is above the maximum %v", server, response.RootDispersion, opts.MaxRootDispersion))
+		return result, fmt.Errorf("%w: server %s root dispersion %v is above the maximum %v", ErrRejected, server, response.RootDispersion, opts.MaxRootDispersion)
+	}
+	if opts.MaxRootDelay > 0 && response.RootDelay > opts.MaxRootDelay {
+		slog.Error("Server root delay is above the maximum", "server", server, "root_delay", response.RootDelay, "max", opts.MaxRootDelay)
+		notifier.Err(fmt.Sprintf("Server %s root delay %v is above the maximum %v", server, response.RootDelay, opts.MaxRootDelay))
+		return result, fmt.Errorf("%w: server %s root delay %v is above the maximum %v", ErrRejected, server, response.RootDelay, opts.MaxRootDelay)
+	}
+	// Offsets that jump between samples come from a congested path, none
+	// of them can be trusted.
+	if opts.MaxJitter > 0 && sample.jitter > opts.MaxJitter {
+		slog.Error("Server jitter is above the maximum", "server", server, "jitter", sample.jitter, "max", opts.MaxJitter)
+		notifier.Err(fmt.Sprintf("Server %s jitter %v is above the maximum %v", server, sample.jitter, opts.MaxJitter))
+		return result, fmt.Errorf("%w: server %s jitter %v is above the maximum %v", ErrRejected, server, sample.jitter, opts.MaxJitter)
+	}
+	// A long roundtrip still gives a usable offset, but an asymmetric route
+	// can skew it by up to half the roundtrip.
+	if opts.MaxRTT > 0 && response.RTT > opts.MaxRTT {
+		slog.Warn("Roundtrip above the maximum, offset may be inaccurate", "server", server, "rtt", response.RTT, "max", opts.MaxRTT)
+		notifier.Warning(fmt.Sprintf("Roundtrip %v to %s is above the maximum %v", response.RTT, server, opts.MaxRTT))
+	}
+	// The wall clock may be stepped by someone else during the query,
+	// its duration comes from the monotonic clock.
+	if sample.took > opts.MaxMeasure {
+		slog.Error("Time sync took too long", "duration", sample.took.Milliseconds(), "max", opts.MaxMeasure)
+		notifier.Err(fmt.Sprintf("Time sync took too long (%vms)", sample.took.Milliseconds()))
+		return result, fmt.Errorf("%w (%dms)", ErrSlowMeasurement, sample.took.Milliseconds())
+	}
+	ntimepoch := ntime.UnixMilli()
+	roundtrip := sample.took.Milliseconds()
+	offset := response.ClockOffset.Milliseconds()
+	delta := offset
+	if delta < 0 {
+		delta = -delta
+	}
+
+	if opts.Verbose {
+		localTime := time.Unix(prepoch/1000, (prepoch%1000)*1000000)
+		remoteTime := time.Unix(ntimepoch/1000, (ntimepoch%1000)*1000000)
+		slog.Debug("Local time", "time", opts.FormatTime(localTime), "ms", prepoch%1000)
+		slog.Debug("Remote time", "time", opts.FormatTime(remoteTime), "ms", ntimepoch%1000)
+		slog.Debug("Local before(ms)", "ms", prepoch)
+		slog.Debug("Local after(ms)", "ms", nowpoch)
+		slog.Debug("Estimated roundtrip(ms)", "ms", roundtrip)
+		slog.Debug("NTP roundtrip(ms)", "ms", response.RTT.Milliseconds())
+		refID, refDesc := decodeRefID(response.Stratum, response.ReferenceID)
+		slog.Debug("Reference", "stratum", response.Stratum, "id", refID, "source", refDesc)
+		slog.Debug("Root delay(ms)", "ms", response.RootDelay.Milliseconds())
+		slog.Debug("Root dispersion(ms)", "ms", response.RootDispersion.Milliseconds())
+		slog.Debug("Server precision", "precision", response.Precision)
+		slog.Debug("Estimated offset remote - local(ms)", "ms", offset)
+		notifier.Info(fmt.Sprintf("NTP server=%s addr=%s offset_ms=%d rtt_ms=%d", server, serverIP, offset, roundtrip))
+	}
+
+	if opts.QueryOnly {
+		slog.Debug("Query only, not touching the system time", "offset", offset)
+		return result, nil
+	}
+
+	// A single broken server must not throw the clock hours away, large
+	// offsets are only applied when forced.
+	if err := opts.beyondYear(server, response.ClockOffset); err != nil {
+		return result, err
+	}
+	if !opts.Force && response.ClockOffset.Abs() > opts.PanicThreshold {
+		slog.Error("Offset above the panic threshold, not adjusting", "server", server, "offset", response.ClockOffset.Round(time.Second), "threshold", opts.PanicThreshold)
+		notifier.Err(fmt.Sprintf("Offset %v from %s is above the panic threshold %v, not adjusting", response.ClockOffset.Round(time.Second), server, opts.PanicThreshold))
+		return result, fmt.Errorf("%w: offset %v from %s is above %v", ErrPanic, response.ClockOffset.Round(time.Second), server, opts.PanicThreshold)
+	}
+
+	stepping := delta > opts.StepThreshold.Milliseconds()
+	if stepping && opts.stepRefused(server) {
+		result.Action = ActionSkip
+	} else if stepping && !opts.backwardRefused(server, response.ClockOffset) {
+		ntime, err := opts.stepClock(sample.sent, response.ClockOffset)
+		if err != nil {
+			reportSetError(err, ntime, notifier)
+			return result, err
+		} else {
+			result.Changed = !test
+			result.Action = ActionStep
+			syncHardwareClock(opts)
+			slog.Info("System time set to network time", "server", server, "delta", delta, "rtt_ms", response.RTT.Milliseconds())
+			notifier.Info("System time set to network time")
+		}
+	} else if opts.Slew && delta > 0 {
+		err := slewClock(response.ClockOffset, test)
+		if err != nil {
+			slog.Error("Failed to slew system clock", "error", err)
+			notifier.Err(fmt.Sprintf("Failed to slew system clock: %v", err))
+			return result, err
+		}
+		result.Changed = !test
+		result.Action = ActionSlew
+		slog.Info("System clock slewing to network time", "server", server, "offset", offset)
+		notifier.Info(fmt.Sprintf("System clock slewing by %dms", offset))
+	} else if stepping {
+		// Backwards and not slewing, only reported.
+		result.Action = ActionSkip
+	} else {
+		result.Action = ActionSkip
+		if opts.Verbose {
+			slog.Info("Already in sync, not setting system time", "offset_ms", offset, "threshold", opts.StepThreshold)
+			notifier.Info(fmt.Sprintf("Already in sync, delta < %v, not setting system time", opts.StepThreshold))
+		}
+	}
+
+	return result, nil
+}
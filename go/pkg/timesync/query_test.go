@@ -0,0 +1,148 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package timesync
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/beevik/ntp"
+)
+
+func TestParseIPZone(t *testing.T) {
+	tests := []struct {
+		s    string
+		ip   string
+		zone string
+		ok   bool
+	}{
+		{"fe80::1%eth0", "fe80::1", "eth0", true},
+		{"fe80::1%3", "fe80::1", "3", true},
+		{"2001:db8::1", "2001:db8::1", "", true},
+		{"192.0.2.1", "192.0.2.1", "", true},
+		{"ntp.example", "", "", false},
+		{"[fe80::1%eth0]", "", "", false},
+	}
+	for _, tt := range tests {
+		addr, ok := parseIPZone(tt.s)
+		if ok != tt.ok {
+			t.Errorf("parseIPZone(%q) ok = %v, want %v", tt.s, ok, tt.ok)
+			continue
+		}
+		if ok && (addr.IP.String() != tt.ip || addr.Zone != tt.zone) {
+			t.Errorf("parseIPZone(%q) = %s zone %q, want %s zone %q", tt.s, addr.IP, addr.Zone, tt.ip, tt.zone)
+		}
+	}
+}
+
+func TestScopedAddressKeepsZone(t *testing.T) {
+	opts := &Options{NoDNS: true, LocalAddress: "fe80::2%eth0", LookupIPAddr: func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		t.Fatalf("resolver called for %s with NoDNS", host)
+		return nil, nil
+	}}
+
+	ips, err := resolveServer(context.Background(), "fe80::1%eth0", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ips) != 1 || ips[0].Zone != "eth0" || ips[0].String() != "fe80::1%eth0" {
+		t.Errorf("resolveServer = %v, want fe80::1%%eth0", ips)
+	}
+	if _, err := resolveServer(context.Background(), "ntp.example", opts); err == nil {
+		t.Error("resolveServer accepted a host name with NoDNS")
+	}
+
+	if local, ok := opts.localTCPAddr().(*net.TCPAddr); !ok || local.Zone != "eth0" || local.IP.String() != "fe80::2" {
+		t.Errorf("localTCPAddr = %v, want [fe80::2%%eth0]:0", opts.localTCPAddr())
+	}
+
+	v4, v6 := firstOfFamilies([]string{"fe80::1%eth0", "192.0.2.1", "2001:db8::1"})
+	if v4 != "192.0.2.1" || v6 != "fe80::1%eth0" {
+		t.Errorf("firstOfFamilies = %q, %q, want 192.0.2.1 and fe80::1%%eth0", v4, v6)
+	}
+	if filtered := filterIPs(ips, false, true); len(filtered) != 1 || filtered[0].Zone != "eth0" {
+		t.Errorf("filterIPs with IPv6 only = %v", filtered)
+	}
+}
+
+func TestSyncScopedServer(t *testing.T) {
+	var queried []string
+	opts := offlineOptions(func() (*ntp.Response, error) { return fakeAnswer(0), nil }, &queried)
+	opts.Servers = []string{"[fe80::1%eth0]:1123"}
+	opts.NoDNS = true
+	result, err := Sync(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queried) != 1 || queried[0] != "[fe80::1%eth0]:1123" {
+		t.Errorf("queried %q, want [fe80::1%%eth0]:1123", queried)
+	}
+	if result.IP != "fe80::1%eth0" {
+		t.Errorf("Result.IP = %q, want fe80::1%%eth0", result.IP)
+	}
+}
+
+// TestMeasurementGuardMonotonic checks that a query is judged slow from its
+// monotonic duration: the wall clock readings around it may be far apart
+// after someone else stepped the clock, without the query being slow.
+func TestMeasurementGuardMonotonic(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		prepoch int64
+		nowpoch int64
+		took    time.Duration
+		slow    bool
+	}{
+		{"fast", now.UnixMilli() - 20, now.UnixMilli(), 20 * time.Millisecond, false},
+		{"wall clock stepped forward", now.UnixMilli() - 3600000, now.UnixMilli(), 20 * time.Millisecond, false},
+		{"wall clock stepped backward", now.UnixMilli() + 3600000, now.UnixMilli(), 20 * time.Millisecond, false},
+		{"slow", now.UnixMilli() - 20, now.UnixMilli(), 3 * time.Second, true},
+		{"slow with the wall clock stepped back", now.UnixMilli(), now.UnixMilli(), 3 * time.Second, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sample := &ntpSample{
+				server:   "ntp.test",
+				ip:       "192.0.2.1",
+				response: fakeAnswer(time.Millisecond),
+				sent:     now,
+				prepoch:  tt.prepoch,
+				nowpoch:  tt.nowpoch,
+				took:     tt.took,
+			}
+			opts := (&Options{Test: true, MaxMeasure: time.Second}).withDefaults()
+			_, err := applySample(sample, &opts)
+			if slow := errors.Is(err, ErrSlowMeasurement); slow != tt.slow {
+				t.Errorf("applySample error = %v, slow measurement %v", err, tt.slow)
+			}
+			if !tt.slow && err != nil {
+				t.Errorf("applySample error = %v", err)
+			}
+		})
+	}
+}
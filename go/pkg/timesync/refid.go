@@ -0,0 +1,81 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package timesync
+
+import (
+	"fmt"
+	"strings"
+)
+
+// refClocks describes the common stratum 1 reference identifiers, from the
+// RFC 5905 list and the ones seen on public servers.
+var refClocks = map[string]string{
+	"GOES": "Geosynchronous Orbit Environment Satellite",
+	"GPS":  "Global Positioning System",
+	"GAL":  "Galileo Positioning System",
+	"GLO":  "GLONASS",
+	"BDS":  "BeiDou",
+	"PPS":  "Generic pulse-per-second",
+	"PTP":  "Precision Time Protocol",
+	"PTP0": "Precision Time Protocol",
+	"IRIG": "Inter-Range Instrumentation Group",
+	"WWVB": "LF Radio WWVB Ft. Collins, CO 60 kHz",
+	"DCF":  "LF Radio DCF77 Mainflingen, DE 77.5 kHz",
+	"HBG":  "LF Radio HBG Prangins, HB 75 kHz",
+	"MSF":  "LF Radio MSF Anthorn, UK 60 kHz",
+	"JJY":  "LF Radio JJY Fukushima, JP 40 kHz, Saga, JP 60 kHz",
+	"LORC": "MF Radio LORAN C station, 100 kHz",
+	"TDF":  "MF Radio Allouis, FR 162 kHz",
+	"CHU":  "HF Radio CHU Ottawa, Ontario",
+	"WWV":  "HF Radio WWV Ft. Collins, CO",
+	"WWVH": "HF Radio WWVH Kauai, HI",
+	"NIST": "NIST telephone modem",
+	"ACTS": "NIST telephone modem",
+	"USNO": "USNO telephone modem",
+	"PTB":  "European telephone modem",
+	"LOCL": "Uncalibrated local clock",
+	"ATOM": "Atomic clock",
+	"FREE": "Free running clock",
+}
+
+// decodeRefID returns the reference identifier of a server in a readable
+// form and, when known, what it stands for. Stratum 0 and 1 carry four ASCII
+// characters, a kiss code or a reference clock. Above, it is the IPv4
+// address of the upstream server, or the first bytes of the MD5 hash of its
+// IPv6 address, both shown as a dotted quad.
+func decodeRefID(stratum uint8, id uint32) (string, string) {
+	b := [4]byte{byte(id >> 24), byte(id >> 16), byte(id >> 8), byte(id)}
+	switch stratum {
+	case 0:
+		return strings.TrimRight(string(b[:]), "\x00"), "Kiss-o'-death code"
+	case 1:
+		code := strings.TrimRight(string(b[:]), "\x00")
+		if desc, ok := refClocks[code]; ok {
+			return code, desc
+		}
+		return code, "Unknown reference clock"
+	default:
+		return fmt.Sprintf("%d.%d.%d.%d", b[0], b[1], b[2], b[3]), "Upstream server"
+	}
+}
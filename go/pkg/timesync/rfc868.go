@@ -0,0 +1,91 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package timesync
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"time"
+)
+
+// rfc868Port is the TCP port of the RFC 868 Time Protocol.
+const rfc868Port = "37"
+
+// rfc868Epoch is 1970-01-01 in seconds since 1900-01-01, the RFC 868 epoch.
+const rfc868Epoch = 2208988800
+
+// rfc868Time converts an RFC 868 timestamp to a time. The 32 bit counter
+// wraps in February 2036, values below 2^31 would be before 1968 and are
+// taken to be in the next era.
+func rfc868Time(secs uint32) time.Time {
+	unix := int64(secs) - rfc868Epoch
+	if secs < 1<<31 {
+		unix += 1 << 32
+	}
+	return time.Unix(unix, 0)
+}
+
+// syncRFC868 reads the time of server over the RFC 868 Time Protocol on TCP
+// port 37, whatever NTP port the server was given with. It has one second
+// resolution, see applyCoarse.
+func syncRFC868(ctx context.Context, server string, opts *Options) (Result, error) {
+	opts = opts.forServer(server)
+	notifier := opts.Notifier
+	host, _ := splitServer(server, opts.Port)
+	result := Result{Server: server}
+
+	tctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+	network := "tcp"
+	if opts.IPv4Only {
+		network = "tcp4"
+	} else if opts.IPv6Only {
+		network = "tcp6"
+	}
+	sent := time.Now()
+	d := net.Dialer{LocalAddr: opts.localTCPAddr()}
+	conn, err := d.DialContext(tctx, network, net.JoinHostPort(host, rfc868Port))
+	if err != nil {
+		slog.Error("RFC 868 query failed", "server", server, "error", err)
+		notifier.Err(fmt.Sprintf("RFC 868 query to %s failed: %v", server, err))
+		return result, err
+	}
+	defer conn.Close()
+	context.AfterFunc(tctx, func() { conn.Close() })
+	var buf [4]byte
+	if _, err := io.ReadFull(conn, buf[:]); err != nil {
+		slog.Error("RFC 868 query failed", "server", server, "error", err)
+		notifier.Err(fmt.Sprintf("RFC 868 query to %s failed: %v", server, err))
+		return result, err
+	}
+	rtt := time.Since(sent)
+	result.IP = conn.RemoteAddr().(*net.TCPAddr).IP.String()
+	result.Offset = coarseOffset(rfc868Time(binary.BigEndian.Uint32(buf[:])), sent, rtt)
+	result.RTT = rtt
+	return applyCoarse(result, sent, opts)
+}
@@ -0,0 +1,77 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+//go:build linux && (386 || arm || amd64 || riscv64 || arm64)
+
+package timesync
+
+import (
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// rtcDevice is the hardware clock written by setRTC.
+const rtcDevice = "/dev/rtc0"
+
+// rtcSetTime is RTC_SET_TIME from <linux/rtc.h>, _IOW('p', 0x0a, struct rtc_time).
+const rtcSetTime = 0x4024700a
+
+// rtcTime mirrors struct rtc_time from <linux/rtc.h>.
+type rtcTime struct {
+	sec, min, hour, mday, mon, year, wday, yday, isdst int32
+}
+
+// setRTC writes t in UTC to the hardware clock with the RTC_SET_TIME ioctl,
+// falling back to hwclock when the device cannot be opened.
+func setRTC(t time.Time, test bool) error {
+	if test {
+		return nil
+	}
+	f, err := os.OpenFile(rtcDevice, os.O_WRONLY, 0)
+	if err != nil {
+		if herr := hwclock(test); herr != errNoHwclock {
+			return herr
+		}
+		return err
+	}
+	defer f.Close()
+	t = t.UTC()
+	tm := rtcTime{
+		sec:   int32(t.Second()),
+		min:   int32(t.Minute()),
+		hour:  int32(t.Hour()),
+		mday:  int32(t.Day()),
+		mon:   int32(t.Month()) - 1,
+		year:  int32(t.Year()) - 1900,
+		wday:  int32(t.Weekday()),
+		yday:  int32(t.YearDay()) - 1,
+		isdst: 0,
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), rtcSetTime, uintptr(unsafe.Pointer(&tm)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
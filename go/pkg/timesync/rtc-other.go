@@ -21,25 +21,16 @@
 // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
 // SOFTWARE.
 
-//go:build linux && (amd64 || riscv64 || arm64)
+//go:build !(linux && (386 || arm || amd64 || riscv64 || arm64))
 
-package main
+package timesync
 
-import (
-	"syscall"
-	"time"
-)
+import "time"
 
-func setSystemDate(t time.Time, adj int64, test bool) error {
-	// s := fmt.Sprintf("@%d", t.Unix())
-	// args = []string{"-s", s}
-	// err = exec.Command(date, args...).Run()
-	var tv syscall.Timeval
-	tv.Sec = t.Unix()
-	tv.Usec = (t.UnixMilli()%1000 + adj) * 1000
-	if test {
-		return nil
-	} else {
-		return syscall.Settimeofday(&tv)
-	}
+// setRTC copies the system time to the hardware clock with hwclock where it
+// is installed. The BSD and Darwin kernels update the hardware clock
+// themselves when the time is set.
+func setRTC(t time.Time, test bool) error {
+	_ = t
+	return hwclock(test)
 }
@@ -0,0 +1,63 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package timesync
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"time"
+)
+
+// errNoHwclock is returned by hwclock when the command is not installed.
+var errNoHwclock = errors.New("hwclock not found")
+
+// syncHardwareClock copies the system time to the hardware clock after the
+// system clock was set, so the correction survives a reboot. A failure is
+// logged but does not fail the synchronization.
+func syncHardwareClock(opts *Options) {
+	if !opts.SyncRTC {
+		return
+	}
+	if err := setRTC(time.Now(), opts.Test); err != nil {
+		slog.Warn("Failed to update the hardware clock", "error", err)
+		opts.Notifier.Warning(fmt.Sprintf("Failed to update the hardware clock: %v", err))
+		return
+	}
+	slog.Debug("Hardware clock updated")
+}
+
+// hwclock copies the system time to the hardware clock in UTC with the
+// util-linux hwclock command.
+func hwclock(test bool) error {
+	path, err := exec.LookPath("hwclock")
+	if err != nil {
+		return errNoHwclock
+	}
+	if test {
+		return nil
+	}
+	return exec.Command(path, "--systohc", "--utc").Run()
+}
@@ -0,0 +1,137 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package timesync
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"sort"
+	"time"
+)
+
+// sampleSpacing is the delay between two samples of the same server, kept
+// well apart so public servers do not rate limit us. burstSpacing is used in
+// burst mode instead, short enough for all samples to see the same network
+// conditions.
+const (
+	sampleSpacing = 500 * time.Millisecond
+	burstSpacing  = 100 * time.Millisecond
+)
+
+// DefaultBurstSize is the number of samples taken in burst mode when
+// Options.Samples is not above 1.
+const DefaultBurstSize = 8
+
+// takeSamples takes opts.Samples-1 more samples from the address that gave
+// first, spacing them by spacing, and returns them all starting with first.
+// Failed samples are skipped.
+func takeSamples(ctx context.Context, first *ntpSample, port string, opts *Options, spacing time.Duration) []*ntpSample {
+	samples := []*ntpSample{first}
+	slog.Debug("Sample", "n", 1, "ip", first.ip,
+		"offset_ms", first.response.ClockOffset.Milliseconds(), "rtt_ms", first.response.RTT.Milliseconds())
+	for n := 2; n <= opts.Samples; n++ {
+		if sleep(ctx, spacing) != nil {
+			break
+		}
+		sample, err := queryAddress(ctx, first.server, first.ip, port, opts)
+		if err != nil {
+			slog.Debug("Sample failed", "n", n, "ip", first.ip, "error", err)
+			continue
+		}
+		slog.Debug("Sample", "n", n, "ip", sample.ip,
+			"offset_ms", sample.response.ClockOffset.Milliseconds(), "rtt_ms", sample.response.RTT.Milliseconds())
+		samples = append(samples, sample)
+	}
+	return samples
+}
+
+// bestSample takes opts.Samples-1 more samples from the address that gave
+// first and returns the one with the lowest roundtrip, as advised by RFC 4330.
+// first is kept if nothing better comes back.
+func bestSample(ctx context.Context, first *ntpSample, port string, opts *Options) *ntpSample {
+	samples := takeSamples(ctx, first, port, opts, sampleSpacing)
+	best := *first
+	for _, sample := range samples {
+		if sample.response.RTT < best.response.RTT {
+			best = *sample
+		}
+	}
+	best.jitter = jitter(samples)
+	slog.Debug("Best sample", "ip", best.ip,
+		"offset_ms", best.response.ClockOffset.Milliseconds(), "rtt_ms", best.response.RTT.Milliseconds(), "jitter", best.jitter)
+	return &best
+}
+
+// burstSample takes a burst of closely spaced samples from the address that
+// gave first, drops the slower half by roundtrip and the offset outliers, and
+// averages the offsets of the rest. The returned sample is the fastest one
+// carrying that average.
+func burstSample(ctx context.Context, first *ntpSample, port string, opts *Options) *ntpSample {
+	samples := takeSamples(ctx, first, port, opts, burstSpacing)
+	// The jitter is measured on the samples in the order they were taken,
+	// before they are sorted.
+	spread := jitter(samples)
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i].response.RTT < samples[j].response.RTT
+	})
+	kept := madFilter(samples[:(len(samples)+1)/2], opts.FilterK)
+	var sum time.Duration
+	low, high := kept[0].response.ClockOffset, kept[0].response.ClockOffset
+	for _, sample := range kept {
+		offset := sample.response.ClockOffset
+		sum += offset
+		low, high = min(low, offset), max(high, offset)
+	}
+	mean := sum / time.Duration(len(kept))
+	slog.Debug("Burst", "ip", first.ip, "kept", len(kept), "samples", len(samples),
+		"offset_ms", mean.Milliseconds(), "spread_ms", (high - low).Milliseconds(), "jitter", spread)
+	sample := withOffset(kept[0], mean)
+	sample.jitter = spread
+	return sample
+}
+
+// jitter returns the root mean square of the differences between the
+// offsets of successive samples, zero with fewer than two. A source behind
+// a congested link gives offsets jumping from one sample to the next.
+func jitter(samples []*ntpSample) time.Duration {
+	if len(samples) < 2 {
+		return 0
+	}
+	var sum float64
+	for i := 1; i < len(samples); i++ {
+		d := float64(samples[i].response.ClockOffset - samples[i-1].response.ClockOffset)
+		sum += d * d
+	}
+	return time.Duration(math.Sqrt(sum / float64(len(samples)-1)))
+}
+
+// withOffset returns a copy of sample measuring offset instead.
+func withOffset(sample *ntpSample, offset time.Duration) *ntpSample {
+	response := *sample.response
+	response.ClockOffset = offset
+	copied := *sample
+	copied.response = &response
+	return &copied
+}
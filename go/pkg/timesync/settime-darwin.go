@@ -21,9 +21,9 @@
 // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
 // SOFTWARE.
 
-//go:build darwin && arm64
+//go:build darwin
 
-package main
+package timesync
 
 import (
 	"syscall"
@@ -34,9 +34,7 @@ func setSystemDate(t time.Time, adj int64, test bool) error {
 	// s := fmt.Sprintf("%d%02d%02d%02d%02d.%02d", t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second())
 	// args = []string{"-u", s}
 	// err = exec.Command(date, args...).Run()
-	var tv syscall.Timeval
-	tv.Sec = t.Unix()
-	tv.Usec = int32((t.UnixMilli()%1000 + adj) * 1000)
+	tv := adjustedTimeval(t, adj)
 	if test {
 		return nil
 	} else {
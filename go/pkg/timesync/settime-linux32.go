@@ -0,0 +1,143 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+//go:build linux && (386 || arm)
+
+package timesync
+
+import (
+	"fmt"
+	"log/slog"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// adjOffsetSingleshot is ADJ_OFFSET_SINGLESHOT from <sys/timex.h>, the
+// adjtime(3) style one-off slew in microseconds.
+const adjOffsetSingleshot = 0x8001
+
+// clockRealtime is CLOCK_REALTIME from <time.h>.
+const clockRealtime = 0
+
+// adjFrequency is ADJ_FREQUENCY from <sys/timex.h>, the frequency
+// correction is in ppm scaled by 2^16.
+const adjFrequency = 0x0002
+
+// maxFrequency is the largest frequency correction the kernel accepts,
+// 500ppm scaled by 2^16.
+const maxFrequency = 500 << 16
+
+// sysClockGettime64 and sysClockSettime64 are the time64 system calls added
+// by Linux 5.1, numbered the same on 386 and arm.
+const (
+	sysClockGettime64 = 403
+	sysClockSettime64 = 404
+)
+
+// kernelTimespec is struct __kernel_timespec, whose seconds are 64 bits
+// even on 32 bit architectures.
+type kernelTimespec struct {
+	sec  int64
+	nsec int64
+}
+
+// setSystemDate steps the clock to t. adj is an extra correction in
+// milliseconds added on top of t, callers pass 0 when t is already final.
+// clock_settime64 keeps the full nanosecond precision of t and works past
+// January 2038, clock_settime and then settimeofday are only used when the
+// kernel predates it. Their 32 bit time_t would wrap to 1901, a time that
+// does not fit is an error instead.
+func setSystemDate(t time.Time, adj int64, test bool) error {
+	t = t.Add(time.Duration(adj) * time.Millisecond)
+	fits := fitsTime32(t)
+	if test {
+		if !fits && !time64Supported() {
+			return fmt.Errorf("time %v does not fit a 32 bit time_t and the kernel has no clock_settime64", t.UTC())
+		}
+		return nil
+	}
+	ts64 := kernelTimespec{sec: t.Unix(), nsec: int64(t.Nanosecond())}
+	_, _, errno := syscall.Syscall(sysClockSettime64, clockRealtime, uintptr(unsafe.Pointer(&ts64)), 0)
+	if errno == 0 {
+		return nil
+	}
+	if errno == syscall.EPERM {
+		return errno
+	}
+	if !fits {
+		return fmt.Errorf("time %v does not fit a 32 bit time_t and the kernel has no clock_settime64: %w", t.UTC(), errno)
+	}
+	ts := syscall.NsecToTimespec(t.UnixNano())
+	_, _, errno = syscall.Syscall(syscall.SYS_CLOCK_SETTIME, clockRealtime, uintptr(unsafe.Pointer(&ts)), 0)
+	if errno == 0 {
+		return nil
+	}
+	if errno == syscall.EPERM {
+		return errno
+	}
+	tv := adjustedTimeval(t, 0)
+	return syscall.Settimeofday(&tv)
+}
+
+// time64Supported reports whether the kernel has the time64 system calls,
+// probed with clock_gettime64 so that a dry run needs no privileges.
+func time64Supported() bool {
+	var ts kernelTimespec
+	_, _, errno := syscall.Syscall(sysClockGettime64, clockRealtime, uintptr(unsafe.Pointer(&ts)), 0)
+	return errno == 0
+}
+
+// slewSystemClock asks the kernel to gradually absorb offset instead of
+// stepping the clock. The kernel slews at most 500ppm, so this is only meant
+// for small corrections, offsets beyond maxSlew32 are clamped to it.
+func slewSystemClock(offset time.Duration, test bool) error {
+	if clamped := clampSlew32(offset); clamped != offset {
+		slog.Warn("Offset beyond the 32 bit slew range, slewing part of it", "offset", offset, "slew", clamped)
+		offset = clamped
+	}
+	var tx syscall.Timex
+	tx.Modes = adjOffsetSingleshot
+	tx.Offset = int32(offset.Microseconds())
+	if test {
+		return nil
+	}
+	_, err := syscall.Adjtimex(&tx)
+	return err
+}
+
+// adjustFrequency adds ppm to the frequency correction of the kernel clock,
+// clamped to what the kernel accepts. Positive values speed the clock up.
+func adjustFrequency(ppm float64, test bool) error {
+	var tx syscall.Timex
+	if _, err := syscall.Adjtimex(&tx); err != nil {
+		return err
+	}
+	tx.Modes = adjFrequency
+	tx.Freq = max(-maxFrequency, min(tx.Freq+int32(ppm*65536), maxFrequency))
+	if test {
+		return nil
+	}
+	_, err := syscall.Adjtimex(&tx)
+	return err
+}
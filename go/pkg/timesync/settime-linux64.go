@@ -0,0 +1,97 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+//go:build linux && (amd64 || riscv64 || arm64)
+
+package timesync
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// adjOffsetSingleshot is ADJ_OFFSET_SINGLESHOT from <sys/timex.h>, the
+// adjtime(3) style one-off slew in microseconds.
+const adjOffsetSingleshot = 0x8001
+
+// clockRealtime is CLOCK_REALTIME from <time.h>.
+const clockRealtime = 0
+
+// adjFrequency is ADJ_FREQUENCY from <sys/timex.h>, the frequency
+// correction is in ppm scaled by 2^16.
+const adjFrequency = 0x0002
+
+// maxFrequency is the largest frequency correction the kernel accepts,
+// 500ppm scaled by 2^16.
+const maxFrequency = 500 << 16
+
+// setSystemDate steps the clock to t. adj is an extra correction in
+// milliseconds added on top of t, callers pass 0 when t is already final.
+// clock_settime keeps the full nanosecond precision of t, settimeofday is
+// only used when the kernel rejects it.
+func setSystemDate(t time.Time, adj int64, test bool) error {
+	if test {
+		return nil
+	}
+	ts := syscall.NsecToTimespec(t.Add(time.Duration(adj) * time.Millisecond).UnixNano())
+	_, _, errno := syscall.Syscall(syscall.SYS_CLOCK_SETTIME, clockRealtime, uintptr(unsafe.Pointer(&ts)), 0)
+	if errno == 0 {
+		return nil
+	}
+	if errno == syscall.EPERM {
+		return errno
+	}
+	tv := adjustedTimeval(t, adj)
+	return syscall.Settimeofday(&tv)
+}
+
+// slewSystemClock asks the kernel to gradually absorb offset instead of
+// stepping the clock. The kernel slews at most 500ppm, so this is only meant
+// for small corrections.
+func slewSystemClock(offset time.Duration, test bool) error {
+	var tx syscall.Timex
+	tx.Modes = adjOffsetSingleshot
+	tx.Offset = offset.Microseconds()
+	if test {
+		return nil
+	}
+	_, err := syscall.Adjtimex(&tx)
+	return err
+}
+
+// adjustFrequency adds ppm to the frequency correction of the kernel clock,
+// clamped to what the kernel accepts. Positive values speed the clock up.
+func adjustFrequency(ppm float64, test bool) error {
+	var tx syscall.Timex
+	if _, err := syscall.Adjtimex(&tx); err != nil {
+		return err
+	}
+	tx.Modes = adjFrequency
+	tx.Freq = max(-maxFrequency, min(tx.Freq+int64(ppm*65536), maxFrequency))
+	if test {
+		return nil
+	}
+	_, err := syscall.Adjtimex(&tx)
+	return err
+}
@@ -0,0 +1,57 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Fallback for Unix platforms without a dedicated settime-*.go file. Keep this
+// constraint in sync with the other settime files.
+
+//go:build unix && !darwin && !(linux && (386 || arm || amd64 || riscv64 || arm64)) && !(freebsd && amd64) && !(netbsd && amd64) && !(openbsd && amd64) && !(solaris && amd64)
+
+package timesync
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+)
+
+// setSystemDate sets the clock through the POSIX date utility. The date
+// command only takes whole seconds, so adj and sub-second precision are lost.
+func setSystemDate(t time.Time, adj int64, test bool) error {
+	if test {
+		return nil
+	} else {
+		_ = adj
+		date := "/bin/date"
+		if _, err := os.Stat(date); err != nil {
+			date = "/usr/bin/date"
+		}
+		s := t.UTC().Format(dateLayout(runtime.GOOS))
+		out, err := exec.Command(date, "-u", s).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%s -u %s: %v: %s", date, s, err, out)
+		}
+		return nil
+	}
+}
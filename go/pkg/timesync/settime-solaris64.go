@@ -23,7 +23,7 @@
 
 //go:build solaris && amd64
 
-package main
+package timesync
 
 import (
 	"fmt"
@@ -0,0 +1,66 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package timesync
+
+import (
+	"math"
+	"syscall"
+	"time"
+)
+
+// maxSlew32 is the largest slew the microsecond offset of a 32 bit timex
+// holds, a little under 36 minutes.
+const maxSlew32 = math.MaxInt32 * time.Microsecond
+
+// fitsTime32 reports whether t can be set through a 32 bit time_t, which
+// wraps to 1901 after 2038-01-19 03:14:07 UTC. Only the seconds count, the
+// fraction goes in a separate field.
+func fitsTime32(t time.Time) bool {
+	return t.Unix() <= math.MaxInt32 && t.Unix() >= math.MinInt32
+}
+
+// adjustedTimeval returns t moved by adj milliseconds as the Timeval of
+// settimeofday. A negative adj is carried into the seconds, the kernel
+// rejects a Usec outside [0, 1e6).
+func adjustedTimeval(t time.Time, adj int64) syscall.Timeval {
+	return syscall.NsecToTimeval(t.Add(time.Duration(adj) * time.Millisecond).UnixNano())
+}
+
+// clampSlew32 bounds offset to maxSlew32 either way, beyond it the offset of
+// a 32 bit timex would wrap to the opposite sign. The rest is left to the
+// next synchronization.
+func clampSlew32(offset time.Duration) time.Duration {
+	return max(-maxSlew32, min(offset, maxSlew32))
+}
+
+// dateLayout returns the layout of the operand setting the clock with
+// date(1) on goos: [[[[[cc]yy]mm]dd]HH]MM[.ss] on the BSDs, the System V
+// mmddHHMM[[cc]yy][.ss] that GNU, macOS and Solaris accept elsewhere.
+func dateLayout(goos string) string {
+	switch goos {
+	case "freebsd", "netbsd", "openbsd", "dragonfly":
+		return "200601021504.05"
+	}
+	return "010215042006.05"
+}
@@ -0,0 +1,127 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package timesync
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestFitsTime32(t *testing.T) {
+	tests := []struct {
+		name string
+		t    time.Time
+		fits bool
+	}{
+		{"now", time.Now(), true},
+		{"2^31-1", time.Unix(math.MaxInt32, 0), true},
+		{"2^31-1 and a fraction", time.Unix(math.MaxInt32, 999999999), true},
+		{"2^31", time.Unix(math.MaxInt32+1, 0), false},
+		{"2040", time.Date(2040, 1, 1, 0, 0, 0, 0, time.UTC), false},
+		{"-2^31", time.Unix(math.MinInt32, 0), true},
+		{"-2^31-1", time.Unix(math.MinInt32-1, 0), false},
+	}
+	for _, tt := range tests {
+		if fits := fitsTime32(tt.t); fits != tt.fits {
+			t.Errorf("%s: fitsTime32(%v) = %v, want %v", tt.name, tt.t.UTC(), fits, tt.fits)
+		}
+	}
+}
+
+func TestAdjustedTimeval(t *testing.T) {
+	second := time.Unix(1767225600, 0)
+	tests := []struct {
+		name string
+		t    time.Time
+		adj  int64
+		want time.Time
+	}{
+		{"-1us", second.Add(-time.Microsecond), 0, time.Unix(1767225599, 999999000)},
+		{"-999999us", second.Add(-999999 * time.Microsecond), 0, time.Unix(1767225599, 1000)},
+		{"-1s exactly", second, -1000, time.Unix(1767225599, 0)},
+		{"-1ms across the second", second.Add(500 * time.Microsecond), -1, time.Unix(1767225599, 999500000)},
+		{"-2.5s", second.Add(time.Millisecond), -2500, time.Unix(1767225597, 501000000)},
+		{"+1ms", second.Add(999500 * time.Microsecond), 1, time.Unix(1767225601, 500000)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tv := adjustedTimeval(tt.t, tt.adj)
+			if tv.Usec < 0 || tv.Usec >= 1e6 {
+				t.Fatalf("Usec = %d, outside [0, 1e6)", tv.Usec)
+			}
+			if got := time.Unix(int64(tv.Sec), int64(tv.Usec)*1000); !got.Equal(tt.want) {
+				t.Errorf("Timeval is %v, want %v", got.UTC(), tt.want.UTC())
+			}
+		})
+	}
+}
+
+func TestClampSlew32(t *testing.T) {
+	tests := []struct {
+		offset time.Duration
+		want   time.Duration
+	}{
+		{0, 0},
+		{300 * time.Millisecond, 300 * time.Millisecond},
+		{-300 * time.Millisecond, -300 * time.Millisecond},
+		{2147 * time.Second, 2147 * time.Second},
+		{maxSlew32, maxSlew32},
+		{2148 * time.Second, maxSlew32},
+		{-time.Hour, -maxSlew32},
+		{24 * time.Hour, maxSlew32},
+	}
+	for _, tt := range tests {
+		got := clampSlew32(tt.offset)
+		if got != tt.want {
+			t.Errorf("clampSlew32(%v) = %v, want %v", tt.offset, got, tt.want)
+		}
+		if us := int32(got.Microseconds()); int64(us) != got.Microseconds() {
+			t.Errorf("clampSlew32(%v) = %v, wraps to %dus in a 32 bit timex", tt.offset, got, us)
+		}
+	}
+}
+
+func TestDateLayout(t *testing.T) {
+	at := time.Date(2026, 3, 4, 5, 6, 7, 800000000, time.UTC)
+	tests := []struct {
+		goos string
+		want string
+	}{
+		{"freebsd", "202603040506.07"},
+		{"netbsd", "202603040506.07"},
+		{"openbsd", "202603040506.07"},
+		{"dragonfly", "202603040506.07"},
+		{"darwin", "030405062026.07"},
+		{"solaris", "030405062026.07"},
+		{"illumos", "030405062026.07"},
+		{"aix", "030405062026.07"},
+		{"linux", "030405062026.07"},
+	}
+	for _, tt := range tests {
+		if got := at.Format(dateLayout(tt.goos)); got != tt.want {
+			t.Errorf("%s: date operand = %s, want %s", tt.goos, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package timesync
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"errors"
+)
+
+// sivKeySize is the key size of AEAD_AES_SIV_CMAC_256, the only algorithm
+// NTS servers are required to support: one AES-128 key for S2V and one for
+// CTR.
+const sivKeySize = 32
+
+// errSIVAuth is returned when a ciphertext does not authenticate.
+var errSIVAuth = errors.New("AES-SIV authentication failed")
+
+// sivSeal encrypts plaintext with AES-SIV (RFC 5297) and returns the
+// synthetic IV followed by the ciphertext. The associated data components,
+// the nonce being the last one for NTS, are authenticated but not
+// encrypted.
+func sivSeal(key []byte, plaintext []byte, ad ...[]byte) ([]byte, error) {
+	mac, ctr, err := sivCiphers(key)
+	if err != nil {
+		return nil, err
+	}
+	v := s2v(mac, append(ad[:len(ad):len(ad)], plaintext))
+	out := make([]byte, len(v)+len(plaintext))
+	copy(out, v)
+	cipher.NewCTR(ctr, sivCounter(v)).XORKeyStream(out[len(v):], plaintext)
+	return out, nil
+}
+
+// sivOpen decrypts and authenticates what sivSeal produced with the same
+// key and associated data.
+func sivOpen(key []byte, sealed []byte, ad ...[]byte) ([]byte, error) {
+	if len(sealed) < aes.BlockSize {
+		return nil, errSIVAuth
+	}
+	mac, ctr, err := sivCiphers(key)
+	if err != nil {
+		return nil, err
+	}
+	v := sealed[:aes.BlockSize]
+	plaintext := make([]byte, len(sealed)-aes.BlockSize)
+	cipher.NewCTR(ctr, sivCounter(v)).XORKeyStream(plaintext, sealed[aes.BlockSize:])
+	if subtle.ConstantTimeCompare(s2v(mac, append(ad[:len(ad):len(ad)], plaintext)), v) != 1 {
+		return nil, errSIVAuth
+	}
+	return plaintext, nil
+}
+
+// sivCiphers splits key into the S2V and CTR block ciphers.
+func sivCiphers(key []byte) (cipher.Block, cipher.Block, error) {
+	if len(key) != sivKeySize {
+		return nil, nil, errors.New("AES-SIV key must be 32 bytes")
+	}
+	mac, err := aes.NewCipher(key[:sivKeySize/2])
+	if err != nil {
+		return nil, nil, err
+	}
+	ctr, err := aes.NewCipher(key[sivKeySize/2:])
+	if err != nil {
+		return nil, nil, err
+	}
+	return mac, ctr, nil
+}
+
+// sivCounter derives the initial CTR counter from the synthetic IV by
+// clearing the top bit of its last two 32-bit words.
+func sivCounter(v []byte) []byte {
+	q := make([]byte, aes.BlockSize)
+	copy(q, v)
+	q[8] &= 0x7f
+	q[12] &= 0x7f
+	return q
+}
+
+// s2v is the S2V construction of RFC 5297 section 2.4 over strings, the
+// last of them being the plaintext.
+func s2v(mac cipher.Block, strings [][]byte) []byte {
+	d := cmac(mac, make([]byte, aes.BlockSize))
+	last := strings[len(strings)-1]
+	for _, s := range strings[:len(strings)-1] {
+		d = dbl(d)
+		subtle.XORBytes(d, d, cmac(mac, s))
+	}
+	var t []byte
+	if len(last) >= aes.BlockSize {
+		t = append([]byte(nil), last...)
+		end := t[len(t)-aes.BlockSize:]
+		subtle.XORBytes(end, end, d)
+	} else {
+		t = dbl(d)
+		padded := make([]byte, aes.BlockSize)
+		copy(padded, last)
+		padded[len(last)] = 0x80
+		subtle.XORBytes(t, t, padded)
+	}
+	return cmac(mac, t)
+}
+
+// cmac computes the AES-CMAC of msg (RFC 4493).
+func cmac(block cipher.Block, msg []byte) []byte {
+	l := make([]byte, aes.BlockSize)
+	block.Encrypt(l, l)
+	k1 := dbl(l)
+	k2 := dbl(k1)
+
+	n := (len(msg) + aes.BlockSize - 1) / aes.BlockSize
+	complete := n > 0 && len(msg)%aes.BlockSize == 0
+	if n == 0 {
+		n = 1
+	}
+	last := make([]byte, aes.BlockSize)
+	copy(last, msg[(n-1)*aes.BlockSize:])
+	if complete {
+		subtle.XORBytes(last, last, k1)
+	} else {
+		last[len(msg)-(n-1)*aes.BlockSize] = 0x80
+		subtle.XORBytes(last, last, k2)
+	}
+
+	x := make([]byte, aes.BlockSize)
+	for i := 0; i < n-1; i++ {
+		subtle.XORBytes(x, x, msg[i*aes.BlockSize:(i+1)*aes.BlockSize])
+		block.Encrypt(x, x)
+	}
+	subtle.XORBytes(x, x, last)
+	block.Encrypt(x, x)
+	return x
+}
+
+// dbl multiplies a block by x in GF(2^128).
+func dbl(b []byte) []byte {
+	out := make([]byte, len(b))
+	var carry byte
+	for i := len(b) - 1; i >= 0; i-- {
+		out[i] = b[i]<<1 | carry
+		carry = b[i] >> 7
+	}
+	if carry != 0 {
+		out[len(out)-1] ^= 0x87
+	}
+	return out
+}
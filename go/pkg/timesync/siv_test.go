@@ -0,0 +1,175 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package timesync
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// unhex decodes s, spaces allowed to keep the test vectors as printed in
+// the RFCs.
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// RFC 4493 section 4, AES-128 key and subkeys.
+const cmacKey = "2b7e1516 28aed2a6 abf71588 09cf4f3c"
+
+func TestCMACSubkeys(t *testing.T) {
+	block, err := aes.NewCipher(unhex(t, cmacKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := make([]byte, aes.BlockSize)
+	block.Encrypt(l, l)
+	if want := unhex(t, "7df76b0c 1ab899b3 3e42f047 b91b546f"); !bytes.Equal(l, want) {
+		t.Fatalf("L = %x, want %x", l, want)
+	}
+	k1 := dbl(l)
+	if want := unhex(t, "fbeed618 35713366 7c85e08f 7236a8de"); !bytes.Equal(k1, want) {
+		t.Errorf("K1 = %x, want %x", k1, want)
+	}
+	if k2, want := dbl(k1), unhex(t, "f7ddac30 6ae266cc f90bc11e e46d513b"); !bytes.Equal(k2, want) {
+		t.Errorf("K2 = %x, want %x", k2, want)
+	}
+}
+
+func TestCMAC(t *testing.T) {
+	const message = "6bc1bee2 2e409f96 e93d7e11 7393172a ae2d8a57 1e03ac9c 9eb76fac 45af8e51 " +
+		"30c81c46 a35ce411 e5fbc119 1a0a52ef f69f2445 df4f9b17 ad2b417b e66c3710"
+	tests := []struct {
+		length int
+		want   string
+	}{
+		{0, "bb1d6929 e9593728 7fa37d12 9b756746"},
+		{16, "070a16b4 6b4d4144 f79bdd9d d04a287c"},
+		{40, "dfa66747 de9ae630 30ca3261 1497c827"},
+		{64, "51f0bebf 7e3b9d92 fc497417 79363cfe"},
+	}
+	block, err := aes.NewCipher(unhex(t, cmacKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := unhex(t, message)
+	for _, tt := range tests {
+		if got, want := cmac(block, msg[:tt.length]), unhex(t, tt.want); !bytes.Equal(got, want) {
+			t.Errorf("AES-CMAC of %d bytes = %x, want %x", tt.length, got, want)
+		}
+	}
+}
+
+// sivVectors are the examples of RFC 5297 appendix A.
+var sivVectors = []struct {
+	name       string
+	key        string
+	ad         []string
+	plaintext  string
+	ciphertext string
+}{
+	{
+		name:       "A.1 deterministic",
+		key:        "fffefdfc fbfaf9f8 f7f6f5f4 f3f2f1f0 f0f1f2f3 f4f5f6f7 f8f9fafb fcfdfeff",
+		ad:         []string{"10111213 14151617 18191a1b 1c1d1e1f 20212223 24252627"},
+		plaintext:  "11223344 55667788 99aabbcc ddee",
+		ciphertext: "85632d07 c6e8f37f 950acd32 0a2ecc93 40c02b96 90c4dc04 daef7f6a fe5c",
+	},
+	{
+		name: "A.2 nonce based",
+		key:  "7f7e7d7c 7b7a7978 77767574 73727170 40414243 44454647 48494a4b 4c4d4e4f",
+		ad: []string{
+			"00112233 44556677 8899aabb ccddeeff deaddada deaddada ffeeddcc bbaa9988 77665544 33221100",
+			"10203040 50607080 90a0",
+			"09f91102 9d74e35b d84156c5 635688c0",
+		},
+		plaintext: "74686973 20697320 736f6d65 20706c61 696e7465 78742074 6f20656e 63727970 " +
+			"74207573 696e6720 5349562d 414553",
+		ciphertext: "7bdb6e3b 432667eb 06f4d14b ff2fbd0f cb900f2f ddbe4043 26601965 c889bf17 " +
+			"dba77ceb 094fa663 b7a3f748 ba8af829 ea64ad54 4a272e9c 485b62a3 fd5c0d",
+	},
+}
+
+func TestSIVKnownAnswers(t *testing.T) {
+	for _, v := range sivVectors {
+		t.Run(v.name, func(t *testing.T) {
+			key, plaintext, want := unhex(t, v.key), unhex(t, v.plaintext), unhex(t, v.ciphertext)
+			var ad [][]byte
+			for _, a := range v.ad {
+				ad = append(ad, unhex(t, a))
+			}
+			sealed, err := sivSeal(key, plaintext, ad...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(sealed, want) {
+				t.Fatalf("sivSeal = %x, want %x", sealed, want)
+			}
+			opened, err := sivOpen(key, sealed, ad...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(opened, plaintext) {
+				t.Errorf("sivOpen = %x, want %x", opened, plaintext)
+			}
+		})
+	}
+}
+
+func TestSIVOpenRejects(t *testing.T) {
+	v := sivVectors[1]
+	key := unhex(t, v.key)
+	ad := [][]byte{unhex(t, v.ad[0]), unhex(t, v.ad[1]), unhex(t, v.ad[2])}
+	sealed := unhex(t, v.ciphertext)
+
+	flipped := bytes.Clone(sealed)
+	flipped[len(flipped)-1] ^= 1
+	otherAD := [][]byte{ad[0], ad[1], bytes.Repeat([]byte{0}, 16)}
+	tests := []struct {
+		name   string
+		sealed []byte
+		ad     [][]byte
+	}{
+		{"ciphertext bit flipped", flipped, ad},
+		{"wrong nonce", sealed, otherAD},
+		{"missing associated data", sealed, ad[:2]},
+		{"shorter than the IV", sealed[:aes.BlockSize-1], ad},
+	}
+	for _, tt := range tests {
+		if _, err := sivOpen(key, tt.sealed, tt.ad...); !errors.Is(err, errSIVAuth) {
+			t.Errorf("%s: sivOpen error = %v, want %v", tt.name, err, errSIVAuth)
+		}
+	}
+	if _, err := sivSeal(key[:16], nil); err == nil {
+		t.Error("sivSeal accepted a 16 byte key")
+	}
+}
@@ -0,0 +1,83 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package timesync
+
+import (
+	"log/slog"
+	"time"
+)
+
+// setClock and slewClock change the system clock, setSystemDate and
+// slewSystemClock of the platform. Tests swap them for fakes recording the
+// corrections.
+var (
+	setClock  = setSystemDate
+	slewClock = slewSystemClock
+)
+
+// stepPause is the delay between two increments of a stepped correction,
+// long enough for the software watching the clock to notice each one.
+const stepPause = 200 * time.Millisecond
+
+// maxStepIncrements bounds the number of increments of a correction, the
+// increment grows beyond Options.StepSize when more would be needed.
+const maxStepIncrements = 100
+
+// stepClock steps the clock by offset, measured at sent. The new time is
+// carried forward from sent with the monotonic clock, a wall clock step
+// since the query cannot skew it. With Options.StepSize the correction is
+// applied in increments of at most that size, stepPause apart. On failure
+// it returns the time it tried to set.
+func (opts *Options) stepClock(sent time.Time, offset time.Duration) (time.Time, error) {
+	size := offset.Abs()
+	if opts.StepSize > 0 && size > opts.StepSize {
+		size = opts.StepSize
+		if n := (offset.Abs() + size - 1) / size; n > maxStepIncrements {
+			size = (offset.Abs() + maxStepIncrements - 1) / maxStepIncrements
+			slog.Warn("Too many increments, raising the step size", "offset", offset, "step_size", size, "max_increments", maxStepIncrements)
+		}
+	}
+	var applied time.Duration
+	for i := 1; ; i++ {
+		step := min(offset.Abs()-applied.Abs(), size)
+		if offset < 0 {
+			step = -step
+		}
+		applied += step
+		ntime := sent.Add(time.Since(sent) + applied)
+		if err := setClock(ntime, 0, opts.Test); err != nil {
+			return ntime, err
+		}
+		if applied == offset {
+			if i > 1 {
+				slog.Info("Clock step increment", "n", i, "step", step, "remaining", time.Duration(0))
+			}
+			return ntime, nil
+		}
+		slog.Info("Clock step increment", "n", i, "step", step, "remaining", offset-applied)
+		if !opts.Test {
+			time.Sleep(stepPause)
+		}
+	}
+}
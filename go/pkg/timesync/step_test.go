@@ -0,0 +1,148 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package timesync
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/beevik/ntp"
+)
+
+// TestStepAnchoredToSend checks that the offset is added to the send
+// instant carried forward to now with the monotonic clock, the time spent
+// since the query is not lost.
+func TestStepAnchoredToSend(t *testing.T) {
+	tests := []struct {
+		name     string
+		offset   time.Duration
+		stepSize time.Duration
+	}{
+		{"forward", 3 * time.Second, 0},
+		{"backward", -3 * time.Second, 0},
+		{"in increments", 3 * time.Second, time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := Options{Test: true, StepSize: tt.stepSize}
+			sent := time.Now().Add(-2 * time.Second)
+			ntime, err := opts.stepClock(sent, tt.offset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d := ntime.Sub(time.Now().Add(tt.offset)).Abs(); d > 100*time.Millisecond {
+				t.Errorf("stepped to %v, %v away from now plus the offset", ntime, d)
+			}
+		})
+	}
+}
+
+// clockCall is a correction recorded by fakeClock.
+type clockCall struct {
+	kind   string // "step" or "slew"
+	t      time.Time
+	adj    int64
+	offset time.Duration
+	test   bool
+}
+
+// fakeClock swaps setClock and slewClock for fakes recording their calls
+// until the test ends, the system clock is never touched.
+func fakeClock(t *testing.T) *[]clockCall {
+	var calls []clockCall
+	set, slew := setClock, slewClock
+	t.Cleanup(func() { setClock, slewClock = set, slew })
+	setClock = func(at time.Time, adj int64, test bool) error {
+		calls = append(calls, clockCall{kind: "step", t: at, adj: adj, test: test})
+		return nil
+	}
+	slewClock = func(offset time.Duration, test bool) error {
+		calls = append(calls, clockCall{kind: "slew", offset: offset, test: test})
+		return nil
+	}
+	return &calls
+}
+
+func TestSlewOrStep(t *testing.T) {
+	tests := []struct {
+		name   string
+		offset time.Duration
+		slew   bool
+		kind   string
+		action Action
+	}{
+		{"small ahead slewed", 120 * time.Millisecond, true, "slew", ActionSlew},
+		{"small behind slewed", -120 * time.Millisecond, true, "slew", ActionSlew},
+		{"at the threshold slewed", 500 * time.Millisecond, true, "slew", ActionSlew},
+		{"large ahead stepped", 3 * time.Second, true, "step", ActionStep},
+		{"large behind stepped", -3 * time.Second, true, "step", ActionStep},
+		{"small without slew left alone", 120 * time.Millisecond, false, "", ActionSkip},
+		{"large without slew stepped", 3 * time.Second, false, "step", ActionStep},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := fakeClock(t)
+			opts := offlineOptions(func() (*ntp.Response, error) { return fakeAnswer(tt.offset), nil }, nil)
+			opts.Test = false
+			opts.Slew = tt.slew
+			opts.StepThreshold = 500 * time.Millisecond
+			result, err := Sync(context.Background(), opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Action != tt.action || result.Changed != (tt.kind != "") {
+				t.Errorf("Action = %q, Changed = %v", result.Action, result.Changed)
+			}
+			if tt.kind == "" {
+				if len(*calls) != 0 {
+					t.Errorf("clock changed: %+v", *calls)
+				}
+				return
+			}
+			if len(*calls) != 1 {
+				t.Fatalf("calls = %+v, want one %s", *calls, tt.kind)
+			}
+			call := (*calls)[0]
+			if call.kind != tt.kind || call.test {
+				t.Fatalf("call = %+v, want a %s outside test mode", call, tt.kind)
+			}
+			switch tt.kind {
+			case "slew":
+				if call.offset != tt.offset {
+					t.Errorf("slewed by %v, want %v", call.offset, tt.offset)
+				}
+			case "step":
+				// The step carries the whole correction in t, adj is
+				// only an extra for callers without a final time.
+				if call.adj != 0 {
+					t.Errorf("adj = %d, want 0", call.adj)
+				}
+				if d := call.t.Sub(time.Now().Add(tt.offset)).Abs(); d > 100*time.Millisecond {
+					t.Errorf("stepped to %v, %v away from now plus the offset", call.t, d)
+				}
+			}
+		})
+	}
+}
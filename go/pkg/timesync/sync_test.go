@@ -0,0 +1,217 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package timesync
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/beevik/ntp"
+)
+
+// fakeAnswer returns a synchronized stratum 2 response measuring offset.
+func fakeAnswer(offset time.Duration) *ntp.Response {
+	return &ntp.Response{
+		Time:        time.Now().Add(offset),
+		ClockOffset: offset,
+		RTT:         10 * time.Millisecond,
+		Stratum:     2,
+		Precision:   time.Microsecond,
+	}
+}
+
+// offlineOptions returns Options resolving every server to 192.0.2.1 and
+// answering every query with answer, in test mode and without retry
+// delays. The addresses queried are appended to queried when not nil.
+func offlineOptions(answer func() (*ntp.Response, error), queried *[]string) Options {
+	return Options{
+		Servers:    []string{"ntp.test"},
+		Test:       true,
+		RetryDelay: -1,
+		LookupIPAddr: func(ctx context.Context, host string) ([]net.IPAddr, error) {
+			return []net.IPAddr{{IP: net.IPv4(192, 0, 2, 1)}}, nil
+		},
+		Query: func(address string, opt ntp.QueryOptions) (*ntp.Response, error) {
+			if queried != nil {
+				*queried = append(*queried, address)
+			}
+			return answer()
+		},
+	}
+}
+
+func TestSyncOffline(t *testing.T) {
+	tests := []struct {
+		name    string
+		answer  *ntp.Response
+		action  Action
+		err     error
+		queries int
+	}{
+		{"in sync", fakeAnswer(20 * time.Millisecond), ActionSkip, nil, 1},
+		{"step", fakeAnswer(3 * time.Second), ActionStep, nil, 1},
+		{"step backwards", fakeAnswer(-3 * time.Second), ActionStep, nil, 1},
+		{"unsynchronized server", &ntp.Response{ClockOffset: 3 * time.Second, Stratum: 16}, "", ErrStratum, 2},
+		{"leap indicator 3", &ntp.Response{ClockOffset: 3 * time.Second, Stratum: 2, Leap: ntp.LeapNotInSync}, "", ErrRejected, 2},
+		{"bad year", fakeAnswer(-20 * 365 * 24 * time.Hour), "", ErrBadYear, 2},
+		{"above panic threshold", fakeAnswer(2 * time.Hour), "", ErrPanic, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var queried []string
+			opts := offlineOptions(func() (*ntp.Response, error) { return tt.answer, nil }, &queried)
+			opts.Retries = 2
+			result, err := Sync(context.Background(), opts)
+			if !errors.Is(err, tt.err) || (tt.err == nil) != (err == nil) {
+				t.Fatalf("Sync error = %v, want %v", err, tt.err)
+			}
+			if result.Action != tt.action {
+				t.Errorf("Action = %q, want %q", result.Action, tt.action)
+			}
+			if result.Changed {
+				t.Error("Changed is set in test mode")
+			}
+			if len(queried) != tt.queries {
+				t.Errorf("%d queries, want %d", len(queried), tt.queries)
+			}
+			for _, address := range queried {
+				if address != "192.0.2.1:123" {
+					t.Errorf("queried %s, want 192.0.2.1:123", address)
+				}
+			}
+			if tt.err == nil && (result.Server != "ntp.test" || result.IP != "192.0.2.1" || result.Offset != tt.answer.ClockOffset) {
+				t.Errorf("Result = %+v", result)
+			}
+		})
+	}
+}
+
+func TestSyncOfflineFailures(t *testing.T) {
+	t.Run("DNS failure", func(t *testing.T) {
+		opts := offlineOptions(nil, nil)
+		dnsErr := &net.DNSError{Err: "no such host", Name: "ntp.test", IsNotFound: true}
+		opts.LookupIPAddr = func(ctx context.Context, host string) ([]net.IPAddr, error) { return nil, dnsErr }
+		if _, err := Sync(context.Background(), opts); !errors.As(err, &dnsErr) {
+			t.Errorf("Sync error = %v, want the DNS error", err)
+		}
+	})
+	t.Run("no answer", func(t *testing.T) {
+		opts := offlineOptions(func() (*ntp.Response, error) { return nil, errors.New("i/o timeout") }, nil)
+		if _, err := Sync(context.Background(), opts); !errors.Is(err, ErrQueryFailed) {
+			t.Errorf("Sync error = %v, want %v", err, ErrQueryFailed)
+		}
+	})
+	t.Run("second pass answers", func(t *testing.T) {
+		n := 0
+		opts := offlineOptions(func() (*ntp.Response, error) {
+			if n++; n == 1 {
+				return nil, errors.New("i/o timeout")
+			}
+			return fakeAnswer(time.Millisecond), nil
+		}, nil)
+		opts.Retries = 2
+		if result, err := Sync(context.Background(), opts); err != nil || result.Action != ActionSkip {
+			t.Errorf("Sync = %+v, %v", result, err)
+		}
+	})
+}
+
+func TestSyncRetriesSlowMeasurement(t *testing.T) {
+	slowFirst := func(queries *int) func() (*ntp.Response, error) {
+		return func() (*ntp.Response, error) {
+			if *queries++; *queries == 1 {
+				time.Sleep(100 * time.Millisecond)
+			}
+			return fakeAnswer(3 * time.Second), nil
+		}
+	}
+	t.Run("retried", func(t *testing.T) {
+		queries := 0
+		opts := offlineOptions(slowFirst(&queries), nil)
+		opts.MaxMeasure = 50 * time.Millisecond
+		opts.Retries = 2
+		result, err := Sync(context.Background(), opts)
+		if err != nil || result.Action != ActionStep {
+			t.Fatalf("Sync = %+v, %v", result, err)
+		}
+		if queries != 2 {
+			t.Errorf("%d queries, want 2", queries)
+		}
+	})
+	t.Run("no retry left", func(t *testing.T) {
+		queries := 0
+		opts := offlineOptions(slowFirst(&queries), nil)
+		opts.MaxMeasure = 50 * time.Millisecond
+		result, err := Sync(context.Background(), opts)
+		if !errors.Is(err, ErrSlowMeasurement) {
+			t.Fatalf("Sync error = %v, want %v", err, ErrSlowMeasurement)
+		}
+		if result.Action != "" {
+			t.Errorf("Action = %q, the clock must be left alone", result.Action)
+		}
+	})
+}
+
+func TestBestWaitsForEverySample(t *testing.T) {
+	queries := 0
+	opts := offlineOptions(func() (*ntp.Response, error) {
+		queries++
+		return fakeAnswer(3 * time.Second), nil
+	}, nil)
+	// The burst spacing alone is above the timeout of a single query.
+	opts.Best = true
+	opts.Burst = true
+	opts.Samples = 3
+	opts.Timeout = 100 * time.Millisecond
+	result, err := Sync(context.Background(), opts)
+	if err != nil || result.Action != ActionStep {
+		t.Fatalf("Sync = %+v, %v", result, err)
+	}
+	if queries != 3 {
+		t.Errorf("%d queries, want 3", queries)
+	}
+}
+
+func TestCollectBudget(t *testing.T) {
+	const timeout = time.Second
+	tests := []struct {
+		name   string
+		opts   Options
+		budget time.Duration
+	}{
+		{"single query", Options{}, timeout},
+		{"samples", Options{Samples: 4}, 4*timeout + 3*sampleSpacing},
+		{"burst", Options{Samples: 8, Burst: true}, 8*timeout + 7*burstSpacing},
+		{"warmup", Options{Warmup: true}, 2 * timeout},
+		{"NTS key exchange", Options{NTS: true, Samples: 2}, 3*timeout + sampleSpacing},
+	}
+	for _, tt := range tests {
+		if budget := collectBudget(timeout, &tt.opts); budget != tt.budget {
+			t.Errorf("%s: collectBudget = %v, want %v", tt.name, budget, tt.budget)
+		}
+	}
+}
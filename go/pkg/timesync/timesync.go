@@ -0,0 +1,528 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package timesync is a minimal SNTP client able to step or slew the system
+// clock. It is the engine behind the timesync command and can be embedded in
+// other programs.
+package timesync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"time"
+
+	"github.com/beevik/ntp"
+)
+
+// Defaults applied by Sync to zero valued Options fields.
+const (
+	DefaultServer         = "pool.ntp.org"
+	DefaultTimeout        = 2000 * time.Millisecond
+	DefaultPort           = 123
+	DefaultVersion        = 4
+	DefaultStepThreshold  = 500 * time.Millisecond
+	DefaultPanicThreshold = 1000 * time.Second
+	DefaultTimeLayout     = "2006-01-02T15:04:05-0700"
+	DefaultMaxMeasure     = 10 * time.Second
+)
+
+// MaxYearOffset is the largest offset applied without Options.ForceYear,
+// Force alone does not lift it.
+const MaxYearOffset = 365 * 24 * time.Hour
+
+// ErrRejected is wrapped by the errors of answers that failed a sanity
+// check, such as an out of range year or an unusable stratum.
+var ErrRejected = errors.New("answer rejected")
+
+// ErrPanic is wrapped by the error returned when the measured offset is
+// above Options.PanicThreshold and the clock was left alone.
+var ErrPanic = errors.New("offset above panic threshold")
+
+// ErrBadYear is wrapped by the error of an answer whose date is outside the
+// 2025-2200 range, it wraps ErrRejected.
+var ErrBadYear = fmt.Errorf("%w: year is out of valid range", ErrRejected)
+
+// ErrStratum is wrapped by the error of an answer from a server that is not
+// synchronized or above Options.MaxStratum, it wraps ErrRejected.
+var ErrStratum = fmt.Errorf("%w: unusable stratum", ErrRejected)
+
+// ErrPermission is matched by the errors of clock changes refused for lack
+// of privileges. It is os.ErrPermission, so the EPERM of the system calls
+// matches it without being wrapped.
+var ErrPermission = os.ErrPermission
+
+// ErrQueryFailed is wrapped by the error returned when no answer came back
+// from a server, every address failed or timed out. DNS failures are
+// returned as *net.DNSError instead.
+var ErrQueryFailed = errors.New("NTP query failed")
+
+// ErrSlowMeasurement is wrapped by the error returned when a query took so
+// long that its offset cannot be trusted, Sync retries with a fresh one.
+var ErrSlowMeasurement = errors.New("measurement took too long")
+
+// Options holds the settings of a synchronization.
+// Fields:
+// - Servers: A list of NTP servers to synchronize with, host or host:port.
+// - Verbose: If true, also reports when the clock is left untouched.
+// - FormatTime: Formats the times logged in verbose mode, DefaultTimeLayout if nil.
+// - Test: If true, does everything but set the system time.
+// - Timeout: Timeout of a single NTP query.
+// - ServerTimeouts: Timeout overrides for some of the Servers, keyed by server.
+// - Retries: Number of passes over the server list, or of attempts per server with RetryPerServer.
+// - RetryMode: Order of the retries, RetryRoundRobin if empty.
+// - MaxQueries: If non zero, total number of NTP packets a Sync may send, whatever Retries and the number of servers.
+// - Best: If true, queries all servers concurrently and keeps the lowest roundtrip.
+// - Slew: If true, offsets below StepThreshold are slewed instead of ignored.
+// - StepThreshold: Offsets above this are corrected by stepping the clock.
+// - StepSize: If non zero, steps are applied in increments of at most this size, a short pause apart.
+// - NoBackward: If true, the clock is never stepped backwards, such offsets are slewed with Slew and only reported otherwise.
+// - StepCooldown: If non zero, the clock is not stepped again until this long after LastStep.
+// - LastStep: Time of the previous step, for StepCooldown.
+// - IPv4Only: If true, only IPv4 addresses of the servers are queried.
+// - IPv6Only: If true, only IPv6 addresses of the servers are queried.
+// - NoDNS: If true, servers must be IP addresses and nothing is resolved.
+// - LocalAddress: If set, local IP address the NTP, NTS-KE and RFC 868 connections are bound to.
+// - Port: Default NTP port for servers given without one.
+// - Version: NTP version of the queries, 3 or 4, DefaultVersion if zero.
+// - Auth: Symmetric key authentication, see LoadAuthKey.
+// - NTS: If true, servers are authenticated with Network Time Security (RFC 8915), their NTS-KE port defaults to 4460.
+// - Samples: Number of queries per server, the lowest roundtrip one is used.
+// - KernelTimestamp: If true, answers are timestamped by the kernel on arrival where supported (Linux).
+// - Warmup: If true, the first answer of a server is discarded and the server queried again.
+// - Burst: If true, the offsets of the faster half of Samples closely spaced queries are averaged.
+// - FilterK: Offsets further than this many median absolute deviations from the median are dropped.
+// - MaxStratum: If non zero, servers with a higher stratum are rejected.
+// - MaxRootDispersion: If non zero, servers with a higher root dispersion are rejected.
+// - MaxRootDelay: If non zero, servers with a higher root delay are rejected.
+// - MaxJitter: If non zero, servers whose offsets jitter more than this across Samples are rejected.
+// - MaxRTT: If non zero, a warning is logged for roundtrips above it.
+// - MaxMeasure: Queries whose clock reads are further apart are retried, DefaultMaxMeasure if zero.
+// - Asymmetry: How much longer the outbound delay is than the return one, the offset is corrected by half of it.
+// - AsymmetryFraction: If non zero, share of the roundtrip spent on the outbound path, overrides Asymmetry.
+// - PanicThreshold: Offsets above this are refused unless Force is set.
+// - Force: If true, offsets above PanicThreshold are applied too, up to MaxYearOffset.
+// - ForceYear: If true, offsets above MaxYearOffset are applied too, with Force.
+// - SyncRTC: If true, the hardware clock is updated after the system clock is stepped.
+// - QueryOnly: If true, only measures the offset, the clock is never touched.
+// - Consensus: If true, queries all servers and uses the offset they agree on.
+// - MinAgree: Number of servers that must agree in consensus mode.
+// - Average: If true, queries all servers and applies the mean of their offsets, outliers dropped.
+// - RetryDelay: Delay before the first retry, DefaultRetryDelay if zero, no delay at all if negative.
+// - BackoffMax: Upper bound of the exponential delay between retries.
+// - Jitter: If true, randomizes the delay between retries.
+// - RFC868: If true, the servers are queried with RFC 868 when every NTP query failed.
+// - HTTPFallback: If set, URL whose Date header is used when every NTP query failed.
+// - Notifier: Receives a copy of the important messages, Discard if nil.
+// - LookupIPAddr: Resolves the server names, net.DefaultResolver.LookupIPAddr if nil.
+// - Query: Performs one NTP exchange, ntp.QueryWithOptions if nil, with LookupIPAddr it allows offline tests.
+type Options struct {
+	Servers           []string
+	Verbose           bool
+	FormatTime        func(time.Time) string
+	Test              bool
+	Timeout           time.Duration
+	ServerTimeouts    map[string]time.Duration
+	Retries           int
+	RetryMode         RetryMode
+	MaxQueries        int
+	Best              bool
+	Slew              bool
+	StepThreshold     time.Duration
+	StepSize          time.Duration
+	NoBackward        bool
+	StepCooldown      time.Duration
+	LastStep          time.Time
+	IPv4Only          bool
+	IPv6Only          bool
+	NoDNS             bool
+	LocalAddress      string
+	Port              int
+	Version           int
+	Auth              ntp.AuthOptions
+	NTS               bool
+	Samples           int
+	KernelTimestamp   bool
+	Warmup            bool
+	Burst             bool
+	FilterK           float64
+	MaxStratum        int
+	MaxRootDispersion time.Duration
+	MaxRootDelay      time.Duration
+	MaxJitter         time.Duration
+	MaxRTT            time.Duration
+	MaxMeasure        time.Duration
+	Asymmetry         time.Duration
+	AsymmetryFraction float64
+	PanicThreshold    time.Duration
+	Force             bool
+	ForceYear         bool
+	SyncRTC           bool
+	QueryOnly         bool
+	Consensus         bool
+	MinAgree          int
+	Average           bool
+	RetryDelay        time.Duration
+	BackoffMax        time.Duration
+	Jitter            bool
+	RFC868            bool
+	HTTPFallback      string
+	Notifier          Notifier
+	LookupIPAddr      func(ctx context.Context, host string) ([]net.IPAddr, error)
+	Query             func(address string, opt ntp.QueryOptions) (*ntp.Response, error)
+
+	// nts is the key exchange result of the server being queried.
+	nts *ntsSession
+	// budget counts the packets sent against MaxQueries.
+	budget *queryBudget
+}
+
+// RetryMode is the order in which Sync retries the servers.
+type RetryMode string
+
+// Retry orders for Options.RetryMode.
+const (
+	// RetryRoundRobin makes Retries passes over the whole server list.
+	RetryRoundRobin RetryMode = "round-robin"
+	// RetryPerServer exhausts the Retries of a server before the next one.
+	RetryPerServer RetryMode = "per-server"
+)
+
+// Action is the correction chosen for the system clock.
+type Action string
+
+// Corrections reported in Result.Action. In test mode they are the ones a
+// real run would have applied.
+const (
+	ActionStep Action = "step"
+	ActionSlew Action = "slew"
+	ActionSkip Action = "skip"
+)
+
+// Notifier receives the messages worth keeping in the system log. It is
+// implemented by *syslog.Writer, the command also provides one writing to the
+// Windows Event Log.
+type Notifier interface {
+	Info(m string) error
+	Notice(m string) error
+	Warning(m string) error
+	Err(m string) error
+}
+
+// Discard is a Notifier dropping every message, used when Options.Notifier
+// is nil.
+var Discard Notifier = discard{}
+
+type discard struct{}
+
+func (discard) Info(string) error    { return nil }
+func (discard) Notice(string) error  { return nil }
+func (discard) Warning(string) error { return nil }
+func (discard) Err(string) error     { return nil }
+
+// Result describes the outcome of a synchronization.
+// Fields:
+// - Server: The server as given in Options.Servers.
+// - IP: The address that answered.
+// - Offset: Measured offset of the remote clock relative to the local one.
+// - RTT: Roundtrip delay of the NTP exchange.
+// - Stratum: Stratum of the server.
+// - Precision: Precision of the server clock, a bound on the accuracy it can offer.
+// - Changed: True if the system clock was stepped or slewed.
+// - Action: Correction chosen for the offset, empty if none was considered.
+type Result struct {
+	Server    string
+	IP        string
+	Offset    time.Duration
+	RTT       time.Duration
+	Stratum   uint8
+	Precision time.Duration
+	Changed   bool
+	Action    Action
+}
+
+// Sync queries the configured servers, up to opts.Retries passes or, with
+// RetryPerServer, up to opts.Retries attempts on each server in turn, and
+// corrects the system clock from the first sane answer. The returned Result
+// describes the last exchange attempted, even on failure. Cancelling ctx
+// aborts the DNS lookup or query in flight and stops any further attempt.
+// With opts.MaxQueries, no more packets than that are sent in all.
+func Sync(ctx context.Context, opts Options) (Result, error) {
+	opts = opts.withDefaults()
+	if opts.MaxQueries > 0 {
+		opts.budget = &queryBudget{max: int64(opts.MaxQueries)}
+	}
+	var result Result
+	var err error
+	// Servers that answered DENY or RSTR are not queried again.
+	denied := map[string]bool{}
+	// Failed queries so far, drives the exponential backoff.
+	failures := 0
+	// Concurrent queries always make passes over the whole list.
+	perServer := opts.RetryMode == RetryPerServer && !opts.Best && !opts.Consensus && !opts.Average
+	if perServer {
+		for _, server := range opts.Servers {
+			// Each server starts with a fresh backoff.
+			failures = 0
+			for attempt := 0; attempt < opts.Retries && !denied[server] && !opts.budget.exhausted(); attempt++ {
+				if ctx.Err() != nil {
+					return result, ctx.Err()
+				}
+				if opts.Verbose {
+					slog.Debug("Attempt at NTP query", "attempt", attempt+1, "server", server)
+				}
+				result, err = timeSync(ctx, server, attempt, &opts)
+				if err == nil {
+					return result, nil
+				}
+				if ferr := opts.afterFailure(ctx, err, &failures, denied, attempt == opts.Retries-1); ferr != nil {
+					return result, ferr
+				}
+			}
+		}
+	}
+	for attempt := 0; attempt < opts.Retries && !perServer && !opts.budget.exhausted(); attempt++ {
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+		if len(denied) == len(opts.Servers) {
+			slog.Error("All servers denied access")
+			break
+		}
+		if opts.Best || opts.Consensus || opts.Average {
+			if opts.Verbose {
+				slog.Debug("Attempt at concurrent NTP query", "attempt", attempt+1, "servers", opts.Servers)
+			}
+			if opts.Consensus {
+				result, err = syncConsensus(ctx, attempt, &opts, denied)
+			} else if opts.Average {
+				result, err = syncAverage(ctx, attempt, &opts, denied)
+			} else {
+				result, err = syncBest(ctx, attempt, &opts, denied)
+			}
+			if err == nil {
+				return result, nil
+			}
+			if ferr := opts.afterFailure(ctx, err, &failures, denied, attempt == opts.Retries-1); ferr != nil {
+				return result, ferr
+			}
+			continue
+		}
+		for _, server := range opts.Servers {
+			if opts.budget.exhausted() {
+				break
+			}
+			if denied[server] {
+				continue
+			}
+			if opts.Verbose {
+				slog.Debug("Attempt at NTP query", "attempt", attempt+1, "server", server)
+			}
+			result, err = timeSync(ctx, server, attempt, &opts)
+			if err == nil {
+				return result, nil
+			}
+			if ferr := opts.afterFailure(ctx, err, &failures, denied, attempt == opts.Retries-1); ferr != nil {
+				return result, ferr
+			}
+		}
+	}
+	if opts.budget.exhausted() {
+		slog.Error("Query budget exhausted, not querying any further", "max_queries", opts.MaxQueries)
+		opts.Notifier.Err(fmt.Sprintf("NTP query budget of %d packets exhausted", opts.MaxQueries))
+		err = errors.Join(err, ErrQueryBudget)
+	}
+	slog.Error("Failed to contact NTP server after retries", "attempts", opts.Retries)
+	opts.Notifier.Err(fmt.Sprintf("NTP query failed after %d attempts", opts.Retries))
+	if opts.RFC868 {
+		for _, server := range opts.Servers {
+			if ctx.Err() != nil {
+				break
+			}
+			rresult, rerr := syncRFC868(ctx, server, &opts)
+			if rerr == nil {
+				return rresult, nil
+			}
+			result = rresult
+			err = errors.Join(err, rerr)
+		}
+	}
+	if opts.HTTPFallback != "" && ctx.Err() == nil {
+		hresult, herr := syncHTTPDate(ctx, &opts)
+		if herr == nil {
+			return hresult, nil
+		}
+		err = errors.Join(err, herr)
+	}
+	return result, fmt.Errorf("giving up after %d attempts: %w", opts.Retries, err)
+}
+
+// afterFailure handles the failure err of a query: kiss-o'-death codes are
+// applied to denied and, unless last is set, it waits for the backoff delay
+// of the failures so far. It returns an error when Sync must stop at once,
+// because ctx is done or retrying cannot grant the missing privileges.
+func (opts *Options) afterFailure(ctx context.Context, err error, failures *int, denied map[string]bool, last bool) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if errors.Is(err, ErrPermission) {
+		return err
+	}
+	delay := opts.backoff(*failures)
+	*failures++
+	if backoff := handleKiss(err, denied); backoff > delay {
+		delay = backoff
+	}
+	if last || opts.budget.exhausted() {
+		return nil
+	}
+	return sleep(ctx, delay)
+}
+
+// beyondYear returns an ErrPanic error, after reporting it, when offset
+// from server is above MaxYearOffset and ForceYear is not set. Such a jump
+// is a broken server far more often than a clock that really lost a year.
+func (opts *Options) beyondYear(server string, offset time.Duration) error {
+	if opts.ForceYear || offset.Abs() <= MaxYearOffset {
+		return nil
+	}
+	slog.Error("Offset above a year, not adjusting", "server", server, "offset", offset.Round(time.Hour))
+	opts.Notifier.Err(fmt.Sprintf("Offset %v from %s is above a year, not adjusting", offset.Round(time.Hour), server))
+	return fmt.Errorf("%w: offset %v from %s is above a year", ErrPanic, offset.Round(time.Hour), server)
+}
+
+// backwardRefused reports, with a warning, that stepping the clock by
+// offset would move it into the past while NoBackward is set. Databases and
+// logs ordered by time break when timestamps go backwards, a slew only
+// slows the clock down and is still allowed.
+func (opts *Options) backwardRefused(server string, offset time.Duration) bool {
+	if !opts.NoBackward || offset >= 0 {
+		return false
+	}
+	slog.Warn("Correction would move the clock backwards, not stepping", "server", server, "offset", offset, "slew", opts.Slew)
+	opts.Notifier.Warning(fmt.Sprintf("Offset %v from %s would move the clock backwards, not stepping", offset, server))
+	return true
+}
+
+// stepRefused reports, with a warning, that the clock must not be stepped
+// because it already was less than StepCooldown ago. Another time daemon
+// stepping it back would otherwise make both fight. A LastStep in the
+// future, after the clock went back, counts as recent too.
+func (opts *Options) stepRefused(server string) bool {
+	if opts.StepCooldown <= 0 || opts.LastStep.IsZero() {
+		return false
+	}
+	since := time.Since(opts.LastStep)
+	if since.Abs() >= opts.StepCooldown {
+		return false
+	}
+	slog.Warn("Clock stepped recently, not stepping again", "server", server, "last_step", since.Round(time.Second), "cooldown", opts.StepCooldown)
+	opts.Notifier.Warning(fmt.Sprintf("Clock stepped %v ago, within the %v cooldown, not stepping again", since.Round(time.Second), opts.StepCooldown))
+	return true
+}
+
+// forServer returns opts with the Timeout set for server in ServerTimeouts,
+// opts itself when it has none.
+func (opts *Options) forServer(server string) *Options {
+	timeout, ok := opts.ServerTimeouts[server]
+	if !ok || timeout <= 0 {
+		return opts
+	}
+	o := *opts
+	o.Timeout = timeout
+	return &o
+}
+
+// withDefaults returns a copy of opts with zero values replaced by defaults.
+func (opts Options) withDefaults() Options {
+	if len(opts.Servers) == 0 {
+		opts.Servers = []string{DefaultServer}
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	if opts.Notifier == nil {
+		opts.Notifier = Discard
+	}
+	if opts.FormatTime == nil {
+		opts.FormatTime = func(t time.Time) string { return t.Format(DefaultTimeLayout) }
+	}
+	if opts.LookupIPAddr == nil {
+		opts.LookupIPAddr = net.DefaultResolver.LookupIPAddr
+	}
+	if opts.Query == nil {
+		opts.Query = ntp.QueryWithOptions
+	}
+	if opts.Retries <= 0 {
+		opts.Retries = 1
+	}
+	if opts.StepThreshold <= 0 {
+		opts.StepThreshold = DefaultStepThreshold
+	}
+	if opts.PanicThreshold <= 0 {
+		opts.PanicThreshold = DefaultPanicThreshold
+	}
+	if opts.Port <= 0 {
+		opts.Port = DefaultPort
+	}
+	if opts.Version <= 0 {
+		opts.Version = DefaultVersion
+	}
+	if opts.Samples <= 0 {
+		opts.Samples = 1
+	}
+	if opts.FilterK <= 0 {
+		opts.FilterK = DefaultFilterK
+	}
+	if opts.Burst && opts.Samples < 2 {
+		opts.Samples = DefaultBurstSize
+	}
+	if opts.MinAgree <= 0 {
+		opts.MinAgree = DefaultMinAgree
+	}
+	if opts.RetryDelay == 0 {
+		opts.RetryDelay = DefaultRetryDelay
+	}
+	if opts.BackoffMax <= 0 {
+		opts.BackoffMax = DefaultBackoffMax
+	}
+	if opts.MaxMeasure <= 0 {
+		opts.MaxMeasure = DefaultMaxMeasure
+	}
+	return opts
+}
+
+// sleep waits for d or until ctx is cancelled.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,115 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"time"
+
+	"js353.com/timesync-mini/pkg/timesync"
+)
+
+// syncState is the content of the -state-file, written after every
+// successful synchronization.
+type syncState struct {
+	LastSuccess time.Time `json:"last_success"`
+	Server      string    `json:"server"`
+	OffsetMS    float64   `json:"offset_ms"`
+	Adjusted    bool      `json:"adjusted"`
+	LastStep    time.Time `json:"last_step,omitzero"`
+}
+
+// readState loads the state file at path.
+func readState(path string) (syncState, error) {
+	var state syncState
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return state, err
+	}
+	err = json.Unmarshal(data, &state)
+	return state, err
+}
+
+// writeState records a successful synchronization in the state file at path.
+// The time of the last step is carried over from the previous state unless
+// the clock was stepped now.
+func writeState(path string, result timesync.Result) error {
+	now := time.Now().UTC()
+	lastStep := lastStepTime(path)
+	if result.Changed && result.Action == timesync.ActionStep {
+		lastStep = now
+	}
+	data, err := json.Marshal(syncState{
+		LastSuccess: now,
+		Server:      result.Server,
+		OffsetMS:    float64(result.Offset) / float64(time.Millisecond),
+		Adjusted:    result.Changed,
+		LastStep:    lastStep,
+	})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(data, '\n'))
+}
+
+// logStateAge reports how long ago the last successful synchronization
+// recorded in the state file at path happened.
+func logStateAge(path string) {
+	state, err := readState(path)
+	if os.IsNotExist(err) {
+		slog.Info("No previous successful sync recorded", "path", path)
+		return
+	}
+	if err != nil {
+		slog.Warn("Failed to read state file", "path", path, "error", err)
+		return
+	}
+	slog.Info("Last successful sync", "ago", time.Since(state.LastSuccess).Round(time.Second),
+		"server", state.Server, "offset_ms", state.OffsetMS)
+}
+
+// syncedWithin returns how long ago the last successful synchronization
+// recorded in the state file at path happened, and whether that is less
+// than d. A missing or unreadable state file, or a sync recorded in the
+// future after the clock went back, is never recent.
+func syncedWithin(path string, d time.Duration) (time.Duration, bool) {
+	state, err := readState(path)
+	if err != nil {
+		return 0, false
+	}
+	ago := time.Since(state.LastSuccess)
+	return ago, ago >= 0 && ago < d
+}
+
+// lastStepTime returns the time of the last step recorded in the state file
+// at path, the zero time if there is none.
+func lastStepTime(path string) time.Time {
+	state, err := readState(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return state.LastStep
+}
@@ -0,0 +1,48 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"log/syslog"
+
+	"js353.com/timesync-mini/pkg/timesync"
+)
+
+// openSyslog connects to the syslog collector given by -syslog-addr, or to
+// the local daemon.
+func openSyslog(cfg *Config) (timesync.Notifier, error) {
+	var w *syslog.Writer
+	var err error
+	if cfg.SyslogAddr != "" {
+		w, err = syslog.Dial(cfg.SyslogProto, cfg.SyslogAddr, syslog.LOG_INFO|syslog.LOG_DAEMON, "ntp_client")
+	} else {
+		w, err = syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, "ntp_client")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
@@ -0,0 +1,75 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSyslogClosedBeforeExit checks that run closes the syslog connection
+// before returning, a collector sees the end of the stream instead of a
+// connection dropped by the exit.
+func TestSyslogClosedBeforeExit(t *testing.T) {
+	t.Setenv("NTP_SERVERS", "")
+	collector, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer collector.Close()
+	received := make(chan string, 1)
+	go func() {
+		conn, err := collector.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var lines []string
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		received <- strings.Join(lines, "\n")
+	}()
+
+	server := startMockSNTP(t, mockSNTP{Offset: 3 * time.Second, Stratum: 2})
+	code := runWith(t, "-check", "-v", "-r", "1", "-config", "/dev/null",
+		"-syslog-proto", "tcp", "-syslog-addr", collector.Addr().String(), server.Addr())
+	if code != exitOffset {
+		t.Fatalf("exit code = %d, want %d", code, exitOffset)
+	}
+	select {
+	case lines := <-received:
+		if !strings.Contains(lines, "ntp_client") {
+			t.Errorf("collector received %q, want the messages of the run", lines)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("syslog connection still open after run returned")
+	}
+}
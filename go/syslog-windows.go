@@ -0,0 +1,61 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+//go:build windows
+
+package main
+
+import (
+	"errors"
+
+	"golang.org/x/sys/windows/svc/eventlog"
+	"js353.com/timesync-mini/pkg/timesync"
+)
+
+// eventID is the event identifier of every message, the source is not
+// registered with a message file so Windows shows the text as is.
+const eventID = 1
+
+// eventLog writes the syslog messages to the Windows Event Log, which has
+// no notice level, notices are recorded as information.
+type eventLog struct {
+	log *eventlog.Log
+}
+
+// openSyslog opens the Windows Event Log, -s has no remote collector here.
+func openSyslog(cfg *Config) (timesync.Notifier, error) {
+	if cfg.SyslogAddr != "" {
+		return nil, errors.New("-syslog-addr is not supported on Windows")
+	}
+	l, err := eventlog.Open("ntp_client")
+	if err != nil {
+		return nil, err
+	}
+	return &eventLog{log: l}, nil
+}
+
+func (e *eventLog) Info(m string) error    { return e.log.Info(eventID, m) }
+func (e *eventLog) Notice(m string) error  { return e.log.Info(eventID, m) }
+func (e *eventLog) Warning(m string) error { return e.log.Warning(eventID, m) }
+func (e *eventLog) Err(m string) error     { return e.log.Error(eventID, m) }
+func (e *eventLog) Close() error           { return e.log.Close() }
@@ -0,0 +1,124 @@
+// timesync - Minimal SNTP client (RFC 5905 subset)
+//
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025 tsupplis
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"js353.com/timesync-mini/pkg/timesync"
+)
+
+// lastSuccessMetric is read back from the previous textfile so a failed run
+// keeps reporting when the clock was last known good.
+const lastSuccessMetric = "timesync_last_success_timestamp_seconds"
+
+// writeTextfile writes result in the Prometheus exposition format for the
+// node_exporter textfile collector. The file is replaced atomically, so the
+// collector never sees a partial file.
+func writeTextfile(path string, result timesync.Result, syncErr error) error {
+	lastSuccess := readLastSuccess(path)
+	if syncErr == nil {
+		lastSuccess = time.Now()
+	}
+	var b strings.Builder
+	writeMetrics(&b, result, syncErr, lastSuccess)
+	return writeFileAtomic(path, []byte(b.String()))
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it over path, so readers see either the old or the new content.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// writeMetrics writes result in the Prometheus exposition format. Offset,
+// rtt and stratum are only written when the synchronization succeeded, the
+// last success time only when there was one.
+func writeMetrics(w io.Writer, result timesync.Result, syncErr error, lastSuccess time.Time) {
+	success := 0
+	if syncErr == nil {
+		success = 1
+		fmt.Fprintf(w, "# HELP timesync_offset_seconds Clock offset to the selected server, remote minus local.\n")
+		fmt.Fprintf(w, "# TYPE timesync_offset_seconds gauge\n")
+		fmt.Fprintf(w, "timesync_offset_seconds{server=%q} %g\n", result.Server, result.Offset.Seconds())
+		fmt.Fprintf(w, "# HELP timesync_rtt_seconds Roundtrip to the selected server.\n")
+		fmt.Fprintf(w, "# TYPE timesync_rtt_seconds gauge\n")
+		fmt.Fprintf(w, "timesync_rtt_seconds{server=%q} %g\n", result.Server, result.RTT.Seconds())
+		fmt.Fprintf(w, "# HELP timesync_stratum Stratum of the selected server.\n")
+		fmt.Fprintf(w, "# TYPE timesync_stratum gauge\n")
+		fmt.Fprintf(w, "timesync_stratum{server=%q} %d\n", result.Server, result.Stratum)
+	}
+	fmt.Fprintf(w, "# HELP timesync_last_sync_success Whether the last synchronization succeeded.\n")
+	fmt.Fprintf(w, "# TYPE timesync_last_sync_success gauge\n")
+	fmt.Fprintf(w, "timesync_last_sync_success %d\n", success)
+	if !lastSuccess.IsZero() {
+		fmt.Fprintf(w, "# HELP %s Unix time of the last successful synchronization.\n", lastSuccessMetric)
+		fmt.Fprintf(w, "# TYPE %s gauge\n", lastSuccessMetric)
+		fmt.Fprintf(w, "%s %f\n", lastSuccessMetric, float64(lastSuccess.UnixNano())/1e9)
+	}
+}
+
+// readLastSuccess returns the last success time found in the textfile at
+// path, or the zero time if there is none.
+func readLastSuccess(path string) time.Time {
+	f, err := os.Open(path)
+	if err != nil {
+		return time.Time{}
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		value, ok := strings.CutPrefix(scanner.Text(), lastSuccessMetric+" ")
+		if !ok {
+			continue
+		}
+		if ts, err := strconv.ParseFloat(value, 64); err == nil && ts > 0 {
+			return time.Unix(0, int64(ts*1e9))
+		}
+	}
+	return time.Time{}
+}